@@ -20,6 +20,8 @@
 package common
 
 import (
+	"encoding/json"
+
 	pb "github.com/hyperledger/fabric-protos-go/peer"
 )
 
@@ -48,6 +50,9 @@ const (
 	ERR16   = "AKC0016"
 	ERR17   = "AKC0017"
 	ERR18   = "AKC0018"
+	ERR19   = "AKC0019"
+	ERR20   = "AKC0020"
+	ERR21   = "AKC0021"
 )
 
 var ResCodeDict = map[string]string{
@@ -70,6 +75,9 @@ var ResCodeDict = map[string]string{
 	"AKC0016": "Proposal Rejected!",
 	"AKC0017": "You have confirmed you cannot reject!",
 	"AKC0018": "Only reject once!",
+	"AKC0019": "Business rule violated!",
+	"AKC0020": "Illegal state transition!",
+	"AKC0021": "State transition not allowed!",
 }
 
 type InvokeResponse struct {
@@ -87,6 +95,7 @@ type ResponseSuccess struct {
 type ResponseError struct {
 	ResCode string
 	Msg     string
+	Details interface{} // optional, e.g. the violations of business rules
 }
 
 func RespondSuccess(res ResponseSuccess) pb.Response {
@@ -100,10 +109,23 @@ func RespondSuccess(res ResponseSuccess) pb.Response {
 	}
 }
 
+// errorMessage is the JSON message of an error response
+type errorMessage struct {
+	Status  string      `json:"status"`
+	Msg     string      `json:"msg"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// RespondError returns an error response whose message is the JSON encoding of err. If the
+// details cannot be encoded, the message holds the encoding error as details instead.
 func RespondError(err ResponseError) pb.Response {
-	msg := "{\"status\":\"" + err.ResCode + "\", \"msg\":\"" + err.Msg + "\"}"
+	bytes, marshalErr := json.Marshal(errorMessage{err.ResCode, err.Msg, err.Details})
+	if marshalErr != nil {
+		// The status, the message and the error are strings, which are always encoded
+		bytes, _ = json.Marshal(errorMessage{err.ResCode, err.Msg, "encoding the details failed with error " + marshalErr.Error()})
+	}
 	return pb.Response{
 		Status:  ERROR,
-		Message: msg,
+		Message: string(bytes),
 	}
 }
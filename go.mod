@@ -3,10 +3,11 @@ module github.com/Akachain/akc-go-sdk-v2
 go 1.14
 
 require (
-	github.com/Knetic/govaluate v3.0.0+incompatible // indirect
+	github.com/Knetic/govaluate v3.0.0+incompatible
 	github.com/Shopify/sarama v1.28.0 // indirect
 	github.com/VictoriaMetrics/fastcache v1.5.8 // indirect
 	github.com/fsouza/go-dockerclient v1.7.2 // indirect
+	github.com/golang/protobuf v1.4.3
 	github.com/hashicorp/go-version v1.3.0 // indirect
 	github.com/hyperledger/fabric v2.1.1+incompatible
 	github.com/hyperledger/fabric-amcl v0.0.0-20210319225857-000ace5745f9 // indirect
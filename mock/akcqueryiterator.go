@@ -56,6 +56,7 @@ func (it *AkcQueryIterator) Next() (*queryresult.KV, error) {
 		return nil, errors.New("empty query result")
 	}
 
+	kv.Key = item.ID
 	kv.Value = item.Value
 
 	return kv, nil
@@ -0,0 +1,260 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package mock
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/version"
+)
+
+// The mock ledger groups the transactions it commits into numbered blocks, starting at 1:
+// every successful MockInvoke and MockInit is a block of its own and MockConcurrentInvoke
+// commits one block holding all its endorsed transactions. A transaction whose response is an
+// error is never part of a block, nor are those run between MockTransactionStart and
+// MockTransactionEnd.
+//
+// Blocks are published to the listeners registered with OnBlock and to the channels returned
+// by BlockEvents and ChaincodeEvents, which resemble the event API of the Fabric Gateway, so
+// that off-chain listeners can be tested without a network.
+
+// BlockEvent describes a committed block.
+type BlockEvent struct {
+	Number       uint64
+	Transactions []TransactionEvent
+}
+
+// TransactionEvent describes a transaction of a block. Like in a real block, transactions
+// invalidated at commit are included with their read/write set, which has not been applied.
+type TransactionEvent struct {
+	TxID           string
+	Timestamp      time.Time
+	ValidationCode pb.TxValidationCode
+	ChaincodeEvent *pb.ChaincodeEvent // the event kept by the peer, nil if the transaction set none
+	Reads          []KVRead
+	Writes         []KVWrite
+}
+
+// KVRead is a key read by a transaction with the version it had, nil if the key did not exist.
+type KVRead struct {
+	Key     string
+	Version *version.Height
+}
+
+// KVWrite is a key written or deleted by a transaction.
+type KVWrite struct {
+	Key      string
+	Value    []byte
+	IsDelete bool
+}
+
+// ChaincodeEvent is a chaincode event of a valid transaction, as delivered by ChaincodeEvents.
+type ChaincodeEvent struct {
+	BlockNumber   uint64
+	TransactionID string
+	ChaincodeName string
+	EventName     string
+	Payload       []byte
+}
+
+// blockLedger holds the committed blocks and the listeners of a MockStubExtend.
+type blockLedger struct {
+	lock      sync.Mutex
+	blocks    []*BlockEvent
+	listeners map[int]func(*BlockEvent)
+	nextID    int
+}
+
+// OnBlock registers a callback that is called with every block committed from now on,
+// synchronously and in order. It returns a function that unregisters the callback.
+func (stub *MockStubExtend) OnBlock(callback func(*BlockEvent)) (unregister func()) {
+	stub.ledger.lock.Lock()
+	defer stub.ledger.lock.Unlock()
+	return stub.addBlockListener(callback)
+}
+
+// addBlockListener registers a listener. The caller must hold the ledger lock.
+func (stub *MockStubExtend) addBlockListener(listener func(*BlockEvent)) func() {
+	if stub.ledger.listeners == nil {
+		stub.ledger.listeners = make(map[int]func(*BlockEvent))
+	}
+	id := stub.ledger.nextID
+	stub.ledger.nextID++
+	stub.ledger.listeners[id] = listener
+	return func() {
+		stub.ledger.lock.Lock()
+		defer stub.ledger.lock.Unlock()
+		delete(stub.ledger.listeners, id)
+	}
+}
+
+// GetBlocks returns the blocks committed so far.
+func (stub *MockStubExtend) GetBlocks() []*BlockEvent {
+	stub.ledger.lock.Lock()
+	defer stub.ledger.lock.Unlock()
+	return append([]*BlockEvent(nil), stub.ledger.blocks...)
+}
+
+// BlockEvents returns a channel that receives the committed blocks, starting with the block
+// numbered startBlock if it has already been committed. The channel is closed once ctx is
+// done. Blocks are queued without limit, so a slow reader never holds up the mock.
+func (stub *MockStubExtend) BlockEvents(ctx context.Context, startBlock uint64) <-chan *BlockEvent {
+	queue := &blockQueue{signal: make(chan struct{}, 1)}
+
+	stub.ledger.lock.Lock()
+	for _, block := range stub.ledger.blocks {
+		if block.Number >= startBlock {
+			queue.push(block)
+		}
+	}
+	unregister := stub.addBlockListener(queue.push)
+	stub.ledger.lock.Unlock()
+
+	out := make(chan *BlockEvent)
+	go func() {
+		defer close(out)
+		defer unregister()
+		for {
+			block, ok := queue.pop()
+			if !ok {
+				select {
+				case <-queue.signal:
+					continue
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case out <- block:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// ChaincodeEvents returns a channel that receives the chaincode events of the valid
+// transactions, starting at block startBlock. The channel is closed once ctx is done.
+func (stub *MockStubExtend) ChaincodeEvents(ctx context.Context, startBlock uint64) <-chan *ChaincodeEvent {
+	blocks := stub.BlockEvents(ctx, startBlock)
+	out := make(chan *ChaincodeEvent)
+	go func() {
+		defer close(out)
+		for block := range blocks {
+			for _, tx := range block.Transactions {
+				if tx.ValidationCode != pb.TxValidationCode_VALID || tx.ChaincodeEvent == nil {
+					continue
+				}
+				event := &ChaincodeEvent{
+					BlockNumber:   block.Number,
+					TransactionID: tx.TxID,
+					ChaincodeName: tx.ChaincodeEvent.ChaincodeId,
+					EventName:     tx.ChaincodeEvent.EventName,
+					Payload:       tx.ChaincodeEvent.Payload,
+				}
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// blockQueue is an unbounded queue of blocks waiting to be read from a channel.
+type blockQueue struct {
+	lock   sync.Mutex
+	items  []*BlockEvent
+	signal chan struct{}
+}
+
+func (queue *blockQueue) push(block *BlockEvent) {
+	queue.lock.Lock()
+	queue.items = append(queue.items, block)
+	queue.lock.Unlock()
+	select {
+	case queue.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (queue *blockQueue) pop() (*BlockEvent, bool) {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	if len(queue.items) == 0 {
+		return nil, false
+	}
+	block := queue.items[0]
+	queue.items = queue.items[1:]
+	return block, true
+}
+
+// publishBlock stores a committed block and hands it to the listeners.
+func (stub *MockStubExtend) publishBlock(block *BlockEvent) {
+	stub.ledger.lock.Lock()
+	stub.ledger.blocks = append(stub.ledger.blocks, block)
+	listeners := make([]func(*BlockEvent), 0, len(stub.ledger.listeners))
+	ids := make([]int, 0, len(stub.ledger.listeners))
+	for id := range stub.ledger.listeners {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		listeners = append(listeners, stub.ledger.listeners[id])
+	}
+	stub.ledger.lock.Unlock()
+
+	for _, listener := range listeners {
+		listener(block)
+	}
+}
+
+// transactionEvent describes a transaction that has just been executed by the mock.
+func (stub *MockStubExtend) transactionEvent(res pb.Response, sim *txSimulation) TransactionEvent {
+	tx := TransactionEvent{
+		TxID:           stub.TxID,
+		ValidationCode: pb.TxValidationCode_VALID,
+		ChaincodeEvent: stub.GetEvent(stub.TxID),
+	}
+	if stub.TxTimestamp != nil {
+		tx.Timestamp = time.Unix(stub.TxTimestamp.GetSeconds(), int64(stub.TxTimestamp.GetNanos())).UTC()
+	}
+
+	keys := make([]string, 0, len(sim.reads))
+	for key := range sim.reads {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		tx.Reads = append(tx.Reads, KVRead{Key: key, Version: sim.reads[key]})
+	}
+	for _, key := range sim.writeOrder {
+		value := sim.writes[key]
+		tx.Writes = append(tx.Writes, KVWrite{Key: key, Value: value, IsDelete: value == nil})
+	}
+	return tx
+}
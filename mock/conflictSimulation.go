@@ -0,0 +1,220 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package mock
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/version"
+)
+
+// ConcurrentTxResult is the outcome of one transaction of MockConcurrentInvoke
+type ConcurrentTxResult struct {
+	TxID           string
+	Response       pb.Response
+	ValidationCode pb.TxValidationCode
+}
+
+// txSimulation holds the read/write set of a transaction while it is endorsed.
+// Like on a peer, the transaction reads the committed state only and does not see its own writes.
+type txSimulation struct {
+	reads      map[string]*version.Height // version of every key read, nil if the key did not exist
+	writes     map[string][]byte          // value of every key written, nil for a delete
+	writeOrder []string                   // keys in the order they were first written
+
+	privateWrites []KVPrivateWrite // private data written, in order; it is not part of the validation
+	rangeQueries  []*rangeQuery    // range queries run, re-executed at validation to detect phantom reads
+
+	richQueries     []string // rich queries run, whose results are never validated
+	richQueryWarned bool     // a warning about rich queries has been reported
+
+	uncommitted bool           // the transaction is never committed, see ExecuteQuery and SetDryRun
+	query       bool           // the transaction is a query, so it must not write nor set events
+	queryWrites QueryWriteMode // how the writes of a query are handled
+	violations  []string       // writes and events attempted by a query
+	warnings    []string       // behaviours of the transaction that may differ on a peer
+
+	endorsement bool     // an execution compared with others, see SetEndorsementCheck
+	divergence  []string // differences between the executions of a rejected transaction
+}
+
+func newTxSimulation() *txSimulation {
+	return &txSimulation{
+		reads:  make(map[string]*version.Height),
+		writes: make(map[string][]byte),
+	}
+}
+
+func (sim *txSimulation) read(key string, ver *version.Height) {
+	if _, found := sim.reads[key]; !found {
+		sim.reads[key] = ver
+	}
+}
+
+func (sim *txSimulation) write(key string, value []byte) {
+	if _, found := sim.writes[key]; !found {
+		sim.writeOrder = append(sim.writeOrder, key)
+	}
+	if len(value) == 0 {
+		value = nil
+	}
+	sim.writes[key] = value
+}
+
+// checkWrite records a write or an event attempted by a query and rejects it if required
+func (sim *txSimulation) checkWrite(operation string, target string) error {
+	if !sim.query {
+		return nil
+	}
+	violation := fmt.Sprintf("%s(%s)", operation, target)
+	sim.violations = append(sim.violations, violation)
+	if sim.queryWrites == QUERY_REJECT_WRITES {
+		return fmt.Errorf("%s is not allowed in a query transaction", violation)
+	}
+	return nil
+}
+
+func (sim *txSimulation) writePrivate(collection string, key string, value []byte) {
+	if len(value) == 0 {
+		value = nil
+	}
+	sim.privateWrites = append(sim.privateWrites, KVPrivateWrite{Collection: collection, Key: key, Value: value, IsDelete: value == nil})
+}
+
+// MockConcurrentInvoke simulates transactions that are submitted at the same time and
+// ordered into a single block. Every transaction is endorsed against the same committed
+// state, then they are validated and committed in order. A transaction that read a key
+// written by an earlier transaction of the block is invalidated with MVCC_READ_CONFLICT,
+// and its writes are discarded, like on a peer. A transaction whose range queries return
+// different results once the earlier transactions are committed is invalidated with
+// PHANTOM_READ_CONFLICT. A transaction whose response is an error, or whose endorsements
+// do not match, would never be submitted by a client: it is left out of the block and
+// reported with ENDORSEMENT_POLICY_FAILURE in its result only. No block is committed if
+// every transaction is left out. In a dry run, see SetDryRun, the transactions are endorsed
+// only: nothing is committed and the endorsed ones are reported with NOT_VALIDATED.
+func (stub *MockStubExtend) MockConcurrentInvoke(txs [][][]byte) []ConcurrentTxResult {
+	results := make([]ConcurrentTxResult, len(txs))
+	simulations := make([]*txSimulation, len(txs))
+	timestamps := make([]*timestamp.Timestamp, len(txs))
+	events := make([]TransactionEvent, len(txs))
+
+	// Endorse every transaction against the current state
+	for i, args := range txs {
+		txID := stub.newTxID()
+		divergence, restore := stub.checkEndorsements(txID, args, stub.cc.Invoke)
+		if len(divergence) > 0 {
+			res, _, _ := stub.rejectEndorsements(txID, divergence)
+			restore()
+			results[i] = ConcurrentTxResult{TxID: txID, Response: res, ValidationCode: pb.TxValidationCode_ENDORSEMENT_POLICY_FAILURE}
+			continue
+		}
+		stub.args = args
+		delete(stub.events, txID)
+		stub.MockTransactionStart(txID)
+		restore()
+		stub.simulation = newTxSimulation()
+		stub.simulation.uncommitted = stub.dryRun
+		res := stub.cc.Invoke(stub)
+		simulations[i] = stub.simulation
+		timestamps[i] = stub.TxTimestamp
+		events[i] = stub.transactionEvent(res, stub.simulation)
+		stub.simulation = nil
+		stub.MockTransactionEnd(txID)
+		results[i] = ConcurrentTxResult{TxID: txID, Response: res}
+		if res.Status >= shim.ERRORTHRESHOLD {
+			results[i].ValidationCode = pb.TxValidationCode_ENDORSEMENT_POLICY_FAILURE
+			simulations[i] = nil
+		}
+	}
+
+	if stub.dryRun {
+		for i, sim := range simulations {
+			if sim != nil {
+				results[i].ValidationCode = pb.TxValidationCode_NOT_VALIDATED
+			}
+		}
+		return results
+	}
+
+	// Validate and commit the endorsed ones as one block
+	block := &BlockEvent{Number: stub.blockNumber + 1}
+	for i, sim := range simulations {
+		if sim == nil {
+			continue
+		}
+		if len(block.Transactions) == 0 {
+			stub.nextBlock()
+		}
+		stub.txNum = uint64(len(block.Transactions))
+		if !stub.validateReads(sim) {
+			mockLogger.Infof("MockStub %s transaction %s invalidated with MVCC_READ_CONFLICT", stub.Name, results[i].TxID)
+			results[i].ValidationCode = pb.TxValidationCode_MVCC_READ_CONFLICT
+		} else if !stub.validateRangeQueries(sim) {
+			mockLogger.Infof("MockStub %s transaction %s invalidated with PHANTOM_READ_CONFLICT", stub.Name, results[i].TxID)
+			results[i].ValidationCode = pb.TxValidationCode_PHANTOM_READ_CONFLICT
+		} else {
+			results[i].ValidationCode = pb.TxValidationCode_VALID
+			stub.TxID, stub.TxTimestamp = results[i].TxID, timestamps[i]
+			stub.commitSimulation(sim)
+			stub.TxID = ""
+		}
+		events[i].ValidationCode = results[i].ValidationCode
+		block.Transactions = append(block.Transactions, events[i])
+	}
+	if len(block.Transactions) > 0 {
+		stub.publishBlock(block)
+	}
+	return results
+}
+
+// commitSimulation applies the writes of the transaction stub.TxID to the state
+func (stub *MockStubExtend) commitSimulation(sim *txSimulation) {
+	for _, key := range sim.writeOrder {
+		if err := stub.commitState(key, sim.writes[key]); err != nil {
+			mockLogger.Errorf("MockStub %s failed to commit key %s of transaction %s: %+v", stub.Name, key, stub.TxID, err)
+		}
+	}
+	for _, write := range sim.privateWrites {
+		if write.IsDelete {
+			delete(stub.PvtState[write.Collection], write.Key)
+		} else if err := stub.MockStub.PutPrivateData(write.Collection, write.Key, write.Value); err != nil {
+			mockLogger.Errorf("MockStub %s failed to commit private key %s of transaction %s: %+v", stub.Name, write.Key, stub.TxID, err)
+		}
+	}
+}
+
+// validateReads checks that no key read by the transaction has changed since it was endorsed
+func (stub *MockStubExtend) validateReads(sim *txSimulation) bool {
+	for key, ver := range sim.reads {
+		if !version.AreSame(ver, stub.keyVersions[key]) {
+			return false
+		}
+	}
+	return true
+}
+
+// nextBlock moves the mock ledger to a new block
+func (stub *MockStubExtend) nextBlock() {
+	stub.blockNumber++
+	stub.txNum = 0
+}
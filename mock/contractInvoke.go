@@ -0,0 +1,232 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package mock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/metadata"
+)
+
+// systemContractName is the contract that contractapi adds to every chaincode
+const systemContractName = "org.hyperledger.fabric"
+
+// ContractError is returned when a transaction function of a contractapi chaincode returns an
+// error, or when contractapi rejects the call. Message is the error message of the response.
+type ContractError struct {
+	Function string
+	Message  string
+	Result   *InvokeResult
+}
+
+func (err *ContractError) Error() string {
+	return fmt.Sprintf("transaction %s of %s failed: %s", err.Result.TxID, err.Function, err.Message)
+}
+
+// IsContractError reports whether err is, or wraps, a ContractError.
+func IsContractError(err error) bool {
+	var contractErr *ContractError
+	return errors.As(err, &contractErr)
+}
+
+// ContractMetadata returns the metadata of the contractapi chaincode of the stub, as returned
+// by org.hyperledger.fabric:GetMetadata. It is read once, outside of any block.
+func (stub *MockStubExtend) ContractMetadata() (*metadata.ContractChaincodeMetadata, error) {
+	if stub.contractMetadata != nil {
+		return stub.contractMetadata, nil
+	}
+
+	args := stub.args
+	defer func() { stub.args = args }()
+	stub.args = [][]byte{[]byte(systemContractName + ":GetMetadata")}
+	// The clock and the seeded transaction IDs are left untouched
+	stub.MockStub.MockTransactionStart("GetMetadata")
+	res := stub.cc.Invoke(stub)
+	stub.MockStub.MockTransactionEnd("GetMetadata")
+	if res.Status >= shim.ERRORTHRESHOLD {
+		return nil, fmt.Errorf("ContractMetadata failed because GetMetadata failed with error %s", res.Message)
+	}
+
+	var contractMetadata metadata.ContractChaincodeMetadata
+	if err := json.Unmarshal(res.Payload, &contractMetadata); err != nil {
+		return nil, fmt.Errorf("ContractMetadata failed because json.Unmarshal failed with error %v", err)
+	}
+	stub.contractMetadata = &contractMetadata
+	return stub.contractMetadata, nil
+}
+
+// SubmitContractTransaction invokes a transaction function of a contractapi chaincode with Go
+// values and decodes the value it returns into returnValue, which may be nil. The function is
+// named "Contract:Function", or just "Function" for the default contract.
+//
+// Arguments are serialized like contractapi expects them: strings, numbers and booleans as
+// text, times in RFC3339 and everything else in JSON. Their number is checked against the
+// metadata of the contract. When the transaction function returns an error, the error is a
+// *ContractError; the InvokeResult is returned whenever the transaction ran.
+func (stub *MockStubExtend) SubmitContractTransaction(
+	function string,
+	returnValue interface{},
+	args ...interface{},
+) (*InvokeResult, error) {
+	return stub.contractTransaction(function, returnValue, args, stub.ExecuteTransaction)
+}
+
+// EvaluateContractTransaction calls a transaction function that only reads the ledger, like
+// SubmitContractTransaction, in a query transaction, see ExecuteQuery. With QUERY_REJECT_WRITES,
+// a function that writes gets an error from the stub; with QUERY_REPORT_WRITES, the writes are
+// listed in the Violations of the result.
+func (stub *MockStubExtend) EvaluateContractTransaction(
+	function string,
+	returnValue interface{},
+	args ...interface{},
+) (*InvokeResult, error) {
+	return stub.contractTransaction(function, returnValue, args, stub.ExecuteQuery)
+}
+
+func (stub *MockStubExtend) contractTransaction(
+	function string,
+	returnValue interface{},
+	args []interface{},
+	execute func([][]byte, InvokeOptions) *InvokeResult,
+) (*InvokeResult, error) {
+	transaction, err := stub.contractTransactionMetadata(function)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != len(transaction.Parameters) {
+		return nil, fmt.Errorf("%s takes %d arguments but %d were given", function, len(transaction.Parameters), len(args))
+	}
+
+	invokeArgs := [][]byte{[]byte(function)}
+	for i, arg := range args {
+		text, err := serializeContractValue(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %s of %s cannot be serialized: %v", transaction.Parameters[i].Name, function, err)
+		}
+		invokeArgs = append(invokeArgs, []byte(text))
+	}
+
+	result := execute(invokeArgs, InvokeOptions{})
+	if !result.OK() {
+		return result, &ContractError{Function: function, Message: result.Message, Result: result}
+	}
+	if returnValue != nil && transaction.Returns.Schema != nil {
+		if err := deserializeContractValue(string(result.Payload), returnValue); err != nil {
+			return result, fmt.Errorf("the value returned by %s cannot be decoded: %v", function, err)
+		}
+	}
+	return result, nil
+}
+
+// contractTransactionMetadata looks a transaction function up in the contract metadata
+func (stub *MockStubExtend) contractTransactionMetadata(function string) (*metadata.TransactionMetadata, error) {
+	contractMetadata, err := stub.ContractMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	contractName, functionName := "", function
+	if i := strings.LastIndex(function, ":"); i >= 0 {
+		contractName, functionName = function[:i], function[i+1:]
+	} else {
+		for name, contract := range contractMetadata.Contracts {
+			if contract.Default {
+				contractName = name
+			}
+		}
+	}
+	contract, found := contractMetadata.Contracts[contractName]
+	if !found {
+		return nil, fmt.Errorf("contract %q of %s is not part of the chaincode", contractName, function)
+	}
+	for i := range contract.Transactions {
+		if contract.Transactions[i].Name == functionName {
+			return &contract.Transactions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("function %s is not part of contract %s", functionName, contractName)
+}
+
+// serializeContractValue turns an argument into the text contractapi parses it from
+func serializeContractValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case time.Time:
+		return v.Format(time.RFC3339), nil
+	case json.RawMessage:
+		return string(v), nil
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.String:
+		return fmt.Sprint(value), nil
+	}
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
+// deserializeContractValue decodes a value returned by contractapi into the value pointed to by target
+func deserializeContractValue(text string, target interface{}) error {
+	pointer := reflect.ValueOf(target)
+	if pointer.Kind() != reflect.Ptr || pointer.IsNil() {
+		return fmt.Errorf("the return value must be a non nil pointer, got %T", target)
+	}
+	value := pointer.Elem()
+	switch v := target.(type) {
+	case *time.Time:
+		t, err := time.Parse(time.RFC3339, text)
+		if err != nil {
+			return err
+		}
+		*v = t
+		return nil
+	case *json.RawMessage:
+		*v = json.RawMessage(text)
+		return nil
+	}
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(text)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+		return nil
+	}
+	if text == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(text), target)
+}
@@ -63,8 +63,8 @@ func getCouchDBConfig() *couchdb.Config {
 		Address:             viper.GetString("ledger.state.couchDBConfig.couchDBAddress"),
 		Username:            viper.GetString("ledger.state.couchDBConfig.username"),
 		Password:            viper.GetString("ledger.state.couchDBConfig.password"),
-		InternalQueryLimit:  1000,
-		MaxBatchUpdateSize:  1000,
+		InternalQueryLimit:  DefaultQueryLimits().InternalQueryLimit,
+		MaxBatchUpdateSize:  viper.GetInt("ledger.state.couchDBConfig.maxBatchUpdateSize"),
 		MaxRetries:          3,
 		MaxRetriesOnStartup: 20,
 		RequestTimeout:      35 * time.Second,
@@ -72,6 +72,9 @@ func getCouchDBConfig() *couchdb.Config {
 		UserCacheSizeMBs:    8,
 	}
 
+	if conf.MaxBatchUpdateSize <= 0 {
+		conf.MaxBatchUpdateSize = 1000
+	}
 	return conf
 }
 
@@ -149,8 +152,13 @@ func (handler *CouchDBHandler) ProcessIndexesForChaincodeDeploy(path string) err
 	return handler.dbEngine.ProcessIndexesForChaincodeDeploy(handler.chaincodeName, fileEntries)
 }
 
-// SaveDocument stores a value in couchDB
+// SaveDocument stores a value in couchDB. Like on a peer, the key and the value are
+// checked by the CouchDB state database first.
 func (handler *CouchDBHandler) SaveDocument(key string, value []byte) error {
+	if err := handler.dbEngine.ValidateKeyValue(key, value); err != nil {
+		return err
+	}
+
 	// unmarshal the value param
 	var doc map[string]interface{}
 	json.Unmarshal(value, &doc)
@@ -164,6 +172,14 @@ func (handler *CouchDBHandler) SaveDocument(key string, value []byte) error {
 	return err
 }
 
+// DeleteDocument removes a document from couchDB, see MockStubExtend.DelState
+func (handler *CouchDBHandler) DeleteDocument(key string) error {
+	batch := statedb.NewUpdateBatch()
+	batch.Delete(handler.chaincodeName, key, version.NewHeight(1, 1))
+	savePoint := version.NewHeight(1, 2)
+	return handler.dbEngine.ApplyUpdates(batch, savePoint)
+}
+
 // QueryDocument executes a query string and return results
 func (handler *CouchDBHandler) QueryDocument(query string) (statedb.ResultsIterator, error) {
 	rs, er := handler.dbEngine.ExecuteQuery(handler.chaincodeName, query)
@@ -0,0 +1,216 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package mock
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// A transaction is endorsed by several peers, which must return the same response and the
+// same read/write set, otherwise the client cannot submit it. Chaincode that iterates over
+// maps, reads the wall clock or depends on the scheduling of goroutines endorses differently
+// on every peer, which a mock executing each transaction once never shows.
+//
+// When the endorsement check is enabled, every invoke transaction is first executed several
+// times against the same committed state, with the same ID, timestamp and proposal, without
+// being committed. The executions are compared and, when they differ, the transaction is
+// rejected with the differences instead of being committed. Go randomizes the iteration order
+// of maps on every iteration, so repeated executions, and separate test runs, see different
+// orders. Every execution, and the one that is committed, gets a signed
+// proposal of its own, so the state util keeps for an invocation starts afresh every time.
+
+// EndorsementCheck configures how many times transactions are executed and compared.
+type EndorsementCheck struct {
+	Endorsers int           // executions compared for every transaction, the check is disabled below 2
+	Interval  time.Duration // wait between executions, so that results depending on the wall clock differ
+}
+
+// SetEndorsementCheck enables the endorsement check for MockInvoke, MockInit,
+// MockConcurrentInvoke and the helpers built on them. Queries are not checked, they are
+// evaluated by a single peer.
+func (stub *MockStubExtend) SetEndorsementCheck(check EndorsementCheck) {
+	stub.endorsementCheck = check
+}
+
+// EndorsementCheck returns the endorsement check of the stub.
+func (stub *MockStubExtend) EndorsementCheck() EndorsementCheck {
+	return stub.endorsementCheck
+}
+
+// checkEndorsements executes a transaction as many times as there are endorsers and returns
+// the differences between the executions, if any. The transaction the caller runs next gets
+// the same context as the executions until restore is called.
+func (stub *MockStubExtend) checkEndorsements(
+	uuid string,
+	args [][]byte,
+	execute func(shim.ChaincodeStubInterface) pb.Response,
+) (divergence []string, restore func()) {
+	if stub.endorsementCheck.Endorsers < 2 {
+		return nil, func() {}
+	}
+
+	// Every endorser gets the same timestamp and nonce, hence the same proposal
+	pendingTimestamp := stub.pendingTimestamp
+	if stub.pendingTimestamp.IsZero() {
+		if stub.clock != nil {
+			stub.pendingTimestamp = stub.clock.tick()
+		} else {
+			stub.pendingTimestamp = time.Now()
+		}
+	}
+	nonce, found := stub.pendingNonces[uuid]
+	if !found {
+		nonce = stub.newNonce()
+	}
+	restore = func() {
+		stub.pendingTimestamp = pendingTimestamp
+		delete(stub.pendingNonces, uuid)
+	}
+
+	first := stub.mockEndorsement(uuid, args, execute, nonce)
+	for endorser := 2; endorser <= stub.endorsementCheck.Endorsers; endorser++ {
+		time.Sleep(stub.endorsementCheck.Interval)
+		runtime.Gosched()
+		if lines := diffLines(first, stub.mockEndorsement(uuid, args, execute, nonce)); len(lines) > 0 {
+			divergence = append([]string{fmt.Sprintf("endorser 1 and endorser %d differ:", endorser)}, lines...)
+			break
+		}
+	}
+	stub.pendingNonces[uuid] = nonce
+	if len(divergence) > 0 {
+		mockLogger.Errorf("MockStub %s transaction %s is not deterministic, %s", stub.Name, uuid, strings.Join(divergence, "\n"))
+	}
+	return divergence, restore
+}
+
+// mockEndorsement executes a transaction without committing it and describes its response,
+// its read/write set and its events, one line each
+func (stub *MockStubExtend) mockEndorsement(
+	uuid string,
+	args [][]byte,
+	execute func(shim.ChaincodeStubInterface) pb.Response,
+	nonce []byte,
+) []string {
+	stub.pendingNonces[uuid] = nonce
+	stub.args = args
+	delete(stub.events, uuid)
+	stub.MockTransactionStart(uuid)
+	sim := newTxSimulation()
+	sim.uncommitted, sim.endorsement = true, true
+	stub.simulation = sim
+	res := execute(stub)
+	tx := stub.transactionEvent(res, sim)
+	events := stub.GetEvents(uuid)
+	stub.simulation = nil
+	stub.MockTransactionEnd(uuid)
+	delete(stub.events, uuid)
+
+	lines := []string{fmt.Sprintf("response %d %q payload %q", res.Status, res.Message, res.Payload)}
+	for _, read := range tx.Reads {
+		if read.Version == nil {
+			lines = append(lines, fmt.Sprintf("read %q absent", read.Key))
+		} else {
+			lines = append(lines, fmt.Sprintf("read %q version %d:%d", read.Key, read.Version.BlockNum, read.Version.TxNum))
+		}
+	}
+	for _, query := range sim.rangeQueries {
+		keys := make([]string, 0, len(query.results))
+		for _, result := range query.results {
+			keys = append(keys, result.Key)
+		}
+		lines = append(lines, fmt.Sprintf("range query [%q, %q) read %q", query.startKey, query.endKey, keys))
+	}
+	for _, query := range sim.richQueries {
+		lines = append(lines, fmt.Sprintf("rich query %s", query))
+	}
+	for _, write := range tx.Writes {
+		if write.IsDelete {
+			lines = append(lines, fmt.Sprintf("delete %q", write.Key))
+		} else {
+			lines = append(lines, fmt.Sprintf("write %q = %q", write.Key, write.Value))
+		}
+	}
+	for _, write := range sim.privateWrites {
+		if write.IsDelete {
+			lines = append(lines, fmt.Sprintf("delete private %s %q", write.Collection, write.Key))
+		} else {
+			lines = append(lines, fmt.Sprintf("write private %s %q = %q", write.Collection, write.Key, write.Value))
+		}
+	}
+	for _, event := range events {
+		lines = append(lines, fmt.Sprintf("event %s payload %q", event.EventName, event.Payload))
+	}
+	return lines
+}
+
+// rejectEndorsements is the outcome of a transaction whose endorsements differ
+func (stub *MockStubExtend) rejectEndorsements(uuid string, divergence []string) (pb.Response, TransactionEvent, *txSimulation) {
+	sim := newTxSimulation()
+	sim.divergence = divergence
+	tx := TransactionEvent{TxID: uuid}
+	if !stub.pendingTimestamp.IsZero() {
+		tx.Timestamp = stub.pendingTimestamp.UTC()
+	}
+	res := shim.Error(fmt.Sprintf("ProposalResponsePayloads do not match, %s", strings.Join(divergence, "\n")))
+	return res, tx, sim
+}
+
+// diffLines lists the lines removed from a and added in b, in order, prefixed with - and +
+func diffLines(a []string, b []string) []string {
+	// lengths[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			case lengths[i+1][j] >= lengths[i][j+1]:
+				lengths[i][j] = lengths[i+1][j]
+			default:
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	diff := make([]string, 0)
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i, j = i+1, j+1
+		case j == len(b) || (i < len(a) && lengths[i+1][j] >= lengths[i][j+1]):
+			diff = append(diff, "- "+a[i])
+			i++
+		default:
+			diff = append(diff, "+ "+b[j])
+			j++
+		}
+	}
+	return diff
+}
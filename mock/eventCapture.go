@@ -0,0 +1,137 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package mock
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// SetEvent records the events set by a transaction. A peer only keeps the last event of a
+// transaction, so every event that replaces an earlier one is reported as a warning of the
+// transaction, whatever its name.
+//
+// Like MockStub, the event is also sent to ChaincodeEventsChannel, unless the channel is full.
+func (stub *MockStubExtend) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return errors.New("event name can not be empty string")
+	}
+	if stub.simulation != nil {
+		if err := stub.simulation.checkWrite("SetEvent", name); err != nil {
+			return err
+		}
+	}
+	event := &pb.ChaincodeEvent{ChaincodeId: stub.Name, TxId: stub.TxID, EventName: name, Payload: payload}
+
+	events := stub.events[stub.TxID]
+	if len(events) > 0 {
+		stub.warnf("event %s replaces event %s: a peer only keeps the last event of a transaction",
+			name, events[len(events)-1].EventName)
+	}
+	stub.events[stub.TxID] = append(events, event)
+
+	// The executions compared by the endorsement check are not delivered
+	if stub.simulation != nil && stub.simulation.endorsement {
+		return nil
+	}
+	select {
+	case stub.ChaincodeEventsChannel <- event:
+	default:
+	}
+	return nil
+}
+
+// GetEvents returns every event set by a transaction, in order.
+func (stub *MockStubExtend) GetEvents(txID string) []*pb.ChaincodeEvent {
+	return stub.events[txID]
+}
+
+// GetEvent returns the event that a peer keeps for a transaction, i.e. the last one set,
+// or nil if the transaction did not set any.
+func (stub *MockStubExtend) GetEvent(txID string) *pb.ChaincodeEvent {
+	events := stub.events[txID]
+	if len(events) == 0 {
+		return nil
+	}
+	return events[len(events)-1]
+}
+
+// AssertEventName checks that the event a peer keeps from events, the last one, has the given
+// name and returns it.
+func AssertEventName(t *testing.T, events []*pb.ChaincodeEvent, name string) *pb.ChaincodeEvent {
+	t.Helper()
+	if len(events) == 0 {
+		t.Errorf("expected event %s but the transaction did not set any event", name)
+		return nil
+	}
+	event := events[len(events)-1]
+	if event.EventName != name {
+		t.Errorf("expected event %s but the transaction kept event %s with payload %s", name, event.EventName, event.Payload)
+	}
+	return event
+}
+
+// AssertNoEvent checks that the transaction did not set any event.
+func AssertNoEvent(t *testing.T, events []*pb.ChaincodeEvent) {
+	t.Helper()
+	for _, event := range events {
+		t.Errorf("expected no event but the transaction set event %s with payload %s", event.EventName, event.Payload)
+	}
+}
+
+// AssertEventPayload checks that the payload of an event is the JSON form of expected. JSON
+// payloads are compared as values, so the order of the fields does not matter. A []byte or a
+// string is compared to the payload as is.
+func AssertEventPayload(t *testing.T, event *pb.ChaincodeEvent, expected interface{}) {
+	t.Helper()
+	if event == nil {
+		t.Errorf("expected an event with payload %v but there is no event", expected)
+		return
+	}
+
+	var expectedBytes []byte
+	switch v := expected.(type) {
+	case []byte:
+		expectedBytes = v
+	case string:
+		expectedBytes = []byte(v)
+	default:
+		var err error
+		if expectedBytes, err = json.Marshal(expected); err != nil {
+			t.Errorf("the expected payload of event %s cannot be marshaled: %v", event.EventName, err)
+			return
+		}
+	}
+
+	var actualValue, expectedValue interface{}
+	if json.Unmarshal(event.Payload, &actualValue) == nil && json.Unmarshal(expectedBytes, &expectedValue) == nil {
+		if !reflect.DeepEqual(actualValue, expectedValue) {
+			t.Errorf("event %s has payload %s, expected %s", event.EventName, event.Payload, expectedBytes)
+		}
+		return
+	}
+	if string(event.Payload) != string(expectedBytes) {
+		t.Errorf("event %s has payload %q, expected %q", event.EventName, event.Payload, expectedBytes)
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// InvokeResult is everything known about a transaction executed by the mock.
+type InvokeResult struct {
+	TxID          string
+	Timestamp     time.Time
+	Args          []string
+	Status        int32
+	Message       string
+	Payload       []byte
+	Envelope      *ResponseEnvelope    // the response decoded from the common package format, if it is one
+	Events        []*pb.ChaincodeEvent // every event set, a peer only keeps the last one
+	Reads         []KVRead
+	Writes        []KVWrite
+	PrivateWrites []KVPrivateWrite
+	Committed     bool     // false for failed transactions, queries and dry runs, see SetDryRun
+	Violations    []string // writes and events attempted by a query, see ExecuteQuery
+	Warnings      []string // behaviours that may differ on a peer, e.g. truncated queries
+	Divergence    []string // differences between the executions of the transaction, see SetEndorsementCheck
+	Duration      time.Duration
+}
+
+// ResponseEnvelope is a response built by common.RespondError, e.g.
+// {"status":"AKC0005","msg":"Insert data fail!"}.
+type ResponseEnvelope struct {
+	Status  string          `json:"status"`
+	Msg     string          `json:"msg"`
+	Details json.RawMessage `json:"details,omitempty"`
+}
+
+// KVPrivateWrite is a key of a private data collection written or deleted by a transaction.
+type KVPrivateWrite struct {
+	Collection string
+	Key        string
+	Value      []byte
+	IsDelete   bool
+}
+
+// OK reports whether the chaincode returned a successful response.
+func (result *InvokeResult) OK() bool {
+	return result.Status < shim.ERRORTHRESHOLD
+}
+
+// Event returns the event that a peer keeps for the transaction, i.e. the last one set, or nil.
+func (result *InvokeResult) Event() *pb.ChaincodeEvent {
+	if len(result.Events) == 0 {
+		return nil
+	}
+	return result.Events[len(result.Events)-1]
+}
+
+// DecodePayload unmarshals the JSON payload of the response into value.
+func (result *InvokeResult) DecodePayload(value interface{}) error {
+	if err := json.Unmarshal(result.Payload, value); err != nil {
+		return fmt.Errorf("DecodePayload failed because json.Unmarshal failed with error %v", err)
+	}
+	return nil
+}
+
+// String describes the transaction, to give context to test failures.
+func (result *InvokeResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "transaction %s %q at %s returned status %d after %s", result.TxID, result.Args,
+		result.Timestamp.Format(time.RFC3339Nano), result.Status, result.Duration)
+	if result.Envelope != nil {
+		fmt.Fprintf(&b, "\n  error: %s %s", result.Envelope.Status, result.Envelope.Msg)
+		if len(result.Envelope.Details) > 0 {
+			fmt.Fprintf(&b, " %s", result.Envelope.Details)
+		}
+	} else if result.Message != "" {
+		fmt.Fprintf(&b, "\n  message: %s", result.Message)
+	}
+	if len(result.Payload) > 0 {
+		fmt.Fprintf(&b, "\n  payload: %s", result.Payload)
+	}
+	for _, event := range result.Events {
+		fmt.Fprintf(&b, "\n  event %s: %s", event.EventName, event.Payload)
+	}
+	for _, read := range result.Reads {
+		fmt.Fprintf(&b, "\n  read %q at version %v", read.Key, read.Version)
+	}
+	for _, write := range result.Writes {
+		if write.IsDelete {
+			fmt.Fprintf(&b, "\n  delete %q", write.Key)
+		} else {
+			fmt.Fprintf(&b, "\n  write %q: %s", write.Key, write.Value)
+		}
+	}
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(&b, "\n  warning: %s", warning)
+	}
+	for _, violation := range result.Violations {
+		fmt.Fprintf(&b, "\n  query attempted %s", violation)
+	}
+	for _, write := range result.PrivateWrites {
+		if write.IsDelete {
+			fmt.Fprintf(&b, "\n  delete private %s %q", write.Collection, write.Key)
+		} else {
+			fmt.Fprintf(&b, "\n  write private %s %q (%d bytes)", write.Collection, write.Key, len(write.Value))
+		}
+	}
+	return b.String()
+}
+
+// ExecuteTransaction invokes the chaincode in a transaction with the given context and, if the
+// chaincode succeeds, commits it as a block of its own, like MockInvoke. It returns everything
+// known about the transaction.
+func (stub *MockStubExtend) ExecuteTransaction(args [][]byte, options InvokeOptions) *InvokeResult {
+	committed := !stub.dryRun
+	result := stub.executeTransaction(args, options, func(txID string) (pb.Response, TransactionEvent, *txSimulation) {
+		return stub.mockBlockTransaction(txID, args, stub.cc.Invoke)
+	})
+	result.Committed = committed && len(result.Divergence) == 0 && result.OK()
+	return result
+}
+
+// executeTransaction runs a transaction with the given context and builds its result
+func (stub *MockStubExtend) executeTransaction(
+	args [][]byte,
+	options InvokeOptions,
+	run func(txID string) (pb.Response, TransactionEvent, *txSimulation),
+) *InvokeResult {
+	result := &InvokeResult{Args: make([]string, 0, len(args))}
+	for _, arg := range args {
+		result.Args = append(result.Args, string(arg))
+	}
+
+	started := time.Now()
+	result.TxID = stub.mockTransactionWithOptions(options, func(txID string) {
+		res, tx, sim := run(txID)
+		result.Duration = time.Since(started)
+		result.Timestamp = tx.Timestamp
+		result.Status, result.Message, result.Payload = res.Status, res.Message, res.Payload
+		result.Reads, result.Writes = tx.Reads, tx.Writes
+		result.PrivateWrites, result.Violations, result.Warnings = sim.privateWrites, sim.violations, sim.warnings
+		result.Divergence = sim.divergence
+	})
+	result.Events = stub.GetEvents(result.TxID)
+	result.Envelope = decodeEnvelope(result.Message)
+	return result
+}
+
+// decodeEnvelope decodes a message built by common.RespondError, nil if it is not one
+func decodeEnvelope(message string) *ResponseEnvelope {
+	var envelope ResponseEnvelope
+	if json.Unmarshal([]byte(message), &envelope) != nil || envelope.Status == "" {
+		return nil
+	}
+	return &envelope
+}
+
+// InvokeTransaction executes an invoke transaction, see ExecuteTransaction, and reports
+// through t.Errorf, with the whole result, when the chaincode returns an error. At most one
+// InvokeOptions can be given.
+func InvokeTransaction(t *testing.T, stub *MockStubExtend, args [][]byte, options ...InvokeOptions) *InvokeResult {
+	t.Helper()
+	result := stub.ExecuteTransaction(args, singleOptions(t, options))
+	if !result.OK() {
+		t.Errorf("expected a successful invoke but %s", result)
+	}
+	return result
+}
+
+// QueryTransaction executes a query transaction, see ExecuteQuery, and reports through
+// t.Errorf, with the whole result, when the chaincode returns an error or attempts to write
+// or to set an event. At most one InvokeOptions can be given.
+func QueryTransaction(t *testing.T, stub *MockStubExtend, args [][]byte, options ...InvokeOptions) *InvokeResult {
+	t.Helper()
+	result := stub.ExecuteQuery(args, singleOptions(t, options))
+	if !result.OK() {
+		t.Errorf("expected a successful query but %s", result)
+	} else if len(result.Violations) > 0 {
+		t.Errorf("expected a read-only query but %s", result)
+	}
+	return result
+}
+
+// InvokeTransactionExpectError executes an invoke transaction and reports through t.Errorf
+// when the chaincode does not return an error, or returns one with another status code of
+// the common package. An empty code accepts any error.
+func InvokeTransactionExpectError(t *testing.T, stub *MockStubExtend, args [][]byte, code string, options ...InvokeOptions) *InvokeResult {
+	t.Helper()
+	result := stub.ExecuteTransaction(args, singleOptions(t, options))
+	switch {
+	case result.OK():
+		t.Errorf("expected an error but %s", result)
+	case code != "" && (result.Envelope == nil || result.Envelope.Status != code):
+		t.Errorf("expected error %s but %s", code, result)
+	}
+	return result
+}
+
+func singleOptions(t *testing.T, options []InvokeOptions) InvokeOptions {
+	t.Helper()
+	switch len(options) {
+	case 0:
+		return InvokeOptions{}
+	case 1:
+		return options[0]
+	default:
+		t.Errorf("expected at most one InvokeOptions, got %d", len(options))
+		return options[0]
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package mock
+
+import (
+	"errors"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// GetHistoryForKey overrides the same function in MockStub that did not implement anything.
+// The mock keeps the committed values of every key, whichever state database is used. Like
+// on a peer, a transaction that writes a key several times leaves a single modification and
+// the modifications are returned from the most recent to the oldest.
+func (stub *MockStubExtend) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	if key == "" {
+		return nil, errors.New("key must not be an empty string")
+	}
+	modifications := stub.history[key]
+	iterator := &historyIterator{items: make([]*queryresult.KeyModification, 0, len(modifications))}
+	for i := len(modifications) - 1; i >= 0; i-- {
+		iterator.items = append(iterator.items, proto.Clone(modifications[i]).(*queryresult.KeyModification))
+	}
+	return iterator, nil
+}
+
+// recordHistory adds a committed write of the current transaction to the history of a key
+func (stub *MockStubExtend) recordHistory(key string, value []byte, isDelete bool) {
+	modification := &queryresult.KeyModification{
+		TxId:      stub.TxID,
+		Value:     append([]byte(nil), value...),
+		Timestamp: stub.TxTimestamp,
+		IsDelete:  isDelete,
+	}
+	modifications := stub.history[key]
+	if last := len(modifications) - 1; last >= 0 && modifications[last].TxId == stub.TxID {
+		modifications[last] = modification
+		return
+	}
+	stub.history[key] = append(modifications, modification)
+}
+
+// historyIterator iterates over the modifications of a key
+type historyIterator struct {
+	items []*queryresult.KeyModification
+	next  int
+}
+
+func (it *historyIterator) HasNext() bool {
+	return it.next < len(it.items)
+}
+
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	if !it.HasNext() {
+		return nil, errors.New("no more modifications")
+	}
+	it.next++
+	return it.items[it.next-1], nil
+}
+
+func (it *historyIterator) Close() error {
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package mock
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb/statecouchdb"
+	"github.com/spf13/viper"
+)
+
+// This is effectively a strongly typed enum declaration.
+type StateDatabase uint8
+
+const (
+	LEVEL_DB StateDatabase = 0 // keys must not be empty
+	COUCH_DB StateDatabase = 1 // keys must also be UTF-8 and not begin with "_", JSON values must not use reserved fields
+)
+
+// Default limits on the size of a value. CouchDB rejects documents larger than its
+// max_document_size and a peer rejects messages larger than peer.maxRecvMsgSize.
+const (
+	DefaultCouchDBMaxValueSize = 8000000
+	DefaultLevelDBMaxValueSize = 100 * 1024 * 1024
+)
+
+// KeyValueRules are the checks that a peer applies to the keys and values written by a
+// transaction, which PutState, DelState, PutPrivateData and DelPrivateData apply as well.
+// A rejected write fails with an error returned to the chaincode, like on a peer.
+type KeyValueRules struct {
+	Database     StateDatabase // the state database whose checks are applied
+	MaxValueSize int           // largest value accepted, in bytes; 0 means no limit
+	Disabled     bool          // skip every check
+}
+
+// DefaultKeyValueRules returns the rules of the state database set in ledger.state.stateDatabase
+// of core.yaml, with the default size limit of that database.
+func DefaultKeyValueRules() KeyValueRules {
+	if strings.EqualFold(viper.GetString("ledger.state.stateDatabase"), "CouchDB") {
+		return KeyValueRules{Database: COUCH_DB, MaxValueSize: DefaultCouchDBMaxValueSize}
+	}
+	return KeyValueRules{Database: LEVEL_DB, MaxValueSize: DefaultLevelDBMaxValueSize}
+}
+
+// Validate checks a key and the value written to it, nil for a delete.
+func (rules KeyValueRules) Validate(key string, value []byte) error {
+	if rules.Disabled {
+		return nil
+	}
+	if key == "" {
+		return errors.New("key must not be an empty string")
+	}
+	if rules.MaxValueSize > 0 && len(value) > rules.MaxValueSize {
+		return fmt.Errorf("invalid value for key [%s], its size of %d bytes exceeds the limit of %d bytes", key, len(value), rules.MaxValueSize)
+	}
+	if rules.Database == COUCH_DB {
+		// The checks of the CouchDB state database do not depend on the database itself
+		return (&statecouchdb.VersionedDB{}).ValidateKeyValue(key, value)
+	}
+	return nil
+}
+
+// SetKeyValueRules replaces the checks applied to the keys and values written through the stub.
+func (stub *MockStubExtend) SetKeyValueRules(rules KeyValueRules) {
+	stub.keyValueRules = rules
+}
+
+// KeyValueRules returns the checks applied to the keys and values written through the stub.
+func (stub *MockStubExtend) KeyValueRules() KeyValueRules {
+	return stub.keyValueRules
+}
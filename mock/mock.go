@@ -35,48 +35,43 @@
 package mock
 
 import (
-	"fmt"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
-	"github.com/satori/go.uuid"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
 	"testing"
 )
 
-// MockInvokeTransaction creates a mock invoke transaction using MockStubExtend
-func MockInvokeTransaction(t *testing.T, stub *MockStubExtend, args [][]byte) string {
-	txId := genTxID()
+// MockInvokeTransaction creates a mock invoke transaction using MockStubExtend.
+// It also returns the events set by the transaction; a peer only keeps the last one.
+func MockInvokeTransaction(t *testing.T, stub *MockStubExtend, args [][]byte) (string, []*pb.ChaincodeEvent) {
+	txId := stub.newTxID()
 	res := stub.MockInvoke(txId, args)
+	events := stub.GetEvents(txId)
 	if res.Status != shim.OK {
-		return string(res.Message)
+		return string(res.Message), events
 	}
 	// fmt.Println(res.Payload)
-	return string(res.Payload)
+	return string(res.Payload), events
 }
 
-// MockQueryTransaction creates a mock query transaction using MockStubExtend
+// MockQueryTransaction creates a mock query transaction using MockStubExtend, see ExecuteQuery.
+// The test is stopped, with the whole result of the transaction, if the query fails or
+// attempts to write or to set an event.
 func MockQueryTransaction(t *testing.T, stub *MockStubExtend, args [][]byte) string {
-	txId := genTxID()
-	res := stub.MockInvoke(txId, args)
-	if res.Status != shim.OK {
-		t.FailNow()
-		return string(res.Message)
+	t.Helper()
+	result := stub.ExecuteQuery(args, InvokeOptions{})
+	if !result.OK() || len(result.Violations) > 0 {
+		t.Fatalf("expected a successful query but %s", result)
+		return result.Message
 	}
-	return string(res.Payload)
+	return string(result.Payload)
 }
 
 // MockIInit creates a mock invoke transaction using MockStubExtend
 func MockInitTransaction(t *testing.T, stub *MockStubExtend, args [][]byte) string {
-	txId := genTxID()
+	txId := stub.newTxID()
 	res := stub.MockInit(txId, args)
 	if res.Status != shim.OK {
 		return string(res.Message)
 	}
 	return string(res.Payload)
 }
-
-// Generate random transaction ID
-func genTxID() string {
-	// or error handling
-	uid := uuid.NewV4()
-	txId := fmt.Sprintf("%s", uid)
-	return txId
-}
@@ -24,10 +24,15 @@ import (
 	"fmt"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/metadata"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
 	pb "github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/version"
 	"github.com/spf13/viper"
+	mathrand "math/rand"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	logging "github.com/op/go-logging"
@@ -47,23 +52,66 @@ type MockStubExtend struct {
 	CouchDB   bool            // if we use couchDB
 	DbHandler *CouchDBHandler // if we use couchDB
 	*shimtest.MockStub
+
+	keyVersions map[string]*version.Height // committed version of every key written through the stub
+	blockNumber uint64                     // number of the block being committed
+	txNum       uint64                     // position of the transaction being committed in its block
+	simulation  *txSimulation              // read/write set of the transaction being endorsed, if any
+
+	history map[string][]*queryresult.KeyModification // committed modifications of every key, oldest first
+	events  map[string][]*pb.ChaincodeEvent           // events set by every transaction
+	ledger  blockLedger                               // committed blocks and their listeners
+
+	clock            *MockClock        // clock giving transactions their timestamp, if any
+	pendingTimestamp time.Time         // timestamp of the next transaction, set by InvokeOptions
+	nonces           *mathrand.Rand    // seeded source of nonces, if any
+	pendingNonces    map[string][]byte // nonces of the generated transaction IDs not started yet
+	nonce            []byte            // nonce of the current transaction
+	signedProposal   *pb.SignedProposal
+	binding          []byte
+
+	contractMetadata *metadata.ContractChaincodeMetadata // metadata of a contractapi chaincode, read on first use
+
+	queryWrites   QueryWriteMode // how the writes of query transactions are handled
+	dryRun        bool           // whether invoke transactions are executed without being committed
+	keyValueRules KeyValueRules  // checks applied to the keys and values written
+	queryLimits   QueryLimits    // limits applied to the results of queries
+
+	richQueryPolicy  RichQueryPolicy  // how transactions that both run rich queries and write are handled
+	endorsementCheck EndorsementCheck // how many times transactions are executed and compared
 }
 
 // GetQueryResult overrides the same function in MockStub
 // that did not implement anything.
 func (stub *MockStubExtend) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	if err := stub.checkRichQuery(query); err != nil {
+		return nil, err
+	}
+	if !stub.CouchDB {
+		return nil, errors.New("GetQueryResult requires CouchDB, see SetCouchDBConfiguration")
+	}
 	// Query data from couchDB
 	raw, err := stub.DbHandler.QueryDocument(query)
 	if err != nil {
 		return nil, err
 	}
-	return FromResultsIterator(raw)
+	iterator, err := FromResultsIterator(raw)
+	if err != nil {
+		return nil, err
+	}
+	return stub.limitQuery(iterator, fmt.Sprintf("GetQueryResult(%s)", query)), nil
 }
 
 // GetQueryResultWithPagination overrides the same function in MockStub
 // that did not implement anything.
 func (stub *MockStubExtend) GetQueryResultWithPagination(query string, pageSize int32,
 	bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if err := stub.checkRichQuery(query); err != nil {
+		return nil, nil, err
+	}
+	if !stub.CouchDB {
+		return nil, nil, errors.New("GetQueryResultWithPagination requires CouchDB, see SetCouchDBConfiguration")
+	}
 
 	raw, er := stub.DbHandler.QueryDocumentWithPagination(query, pageSize, bookmark)
 	if er != nil {
@@ -88,12 +136,18 @@ func NewMockStubExtend(stub *shimtest.MockStub, cc shim.Chaincode, configPath st
 	s.MockStub = stub
 	s.cc = cc
 	s.CouchDB = false
+	s.keyVersions = make(map[string]*version.Height)
+	s.history = make(map[string][]*queryresult.KeyModification)
+	s.events = make(map[string][]*pb.ChaincodeEvent)
+	s.pendingNonces = make(map[string][]byte)
 	viper.SetConfigName("core")
 	viper.AddConfigPath(configPath)
 	err := viper.ReadInConfig() // Find and read the config file
 	if err != nil {             // Handle errors reading the config file
 		panic(fmt.Errorf("Fatal error config file: %s", err))
 	}
+	s.keyValueRules = DefaultKeyValueRules()
+	s.queryLimits = DefaultQueryLimits()
 	return s
 }
 
@@ -101,24 +155,59 @@ func NewMockStubExtend(stub *shimtest.MockStub, cc shim.Chaincode, configPath st
 func (stub *MockStubExtend) SetCouchDBConfiguration(handler *CouchDBHandler) {
 	stub.CouchDB = true
 	stub.DbHandler = handler
+	if stub.keyValueRules.Database != COUCH_DB {
+		stub.keyValueRules = KeyValueRules{Database: COUCH_DB, MaxValueSize: DefaultCouchDBMaxValueSize}
+	}
 }
 
-// MockInvoke Override this function from MockStub
+// MockInvoke Override this function from MockStub. Unlike in MockStub, the transaction is
+// simulated like on a peer: it reads the committed state only, so it does not see its own
+// writes, and its writes are committed, as a block of its own, only if its response is not an
+// error. Writes made between MockTransactionStart and MockTransactionEnd are applied at once.
 func (stub *MockStubExtend) MockInvoke(uuid string, args [][]byte) pb.Response {
-	stub.args = args
-	stub.MockTransactionStart(uuid)
-	res := stub.cc.Invoke(stub)
-	stub.MockTransactionEnd(uuid)
+	res, _, _ := stub.mockBlockTransaction(uuid, args, stub.cc.Invoke)
 	return res
 }
 
-// MockInit Override this function from MockStub
+// MockInit Override this function from MockStub. The transaction is simulated like in
+// MockInvoke.
 func (stub *MockStubExtend) MockInit(uuid string, args [][]byte) pb.Response {
+	res, _, _ := stub.mockBlockTransaction(uuid, args, stub.cc.Init)
+	return res
+}
+
+// mockBlockTransaction executes a transaction and, if the chaincode succeeds, commits it as a
+// block of its own. Like on a peer, the transaction is simulated first: it does not see its own
+// writes, and the writes of a transaction whose response is an error are discarded, without any
+// block. It also returns the transaction as described in the block and its read/write set.
+func (stub *MockStubExtend) mockBlockTransaction(
+	uuid string,
+	args [][]byte,
+	execute func(shim.ChaincodeStubInterface) pb.Response,
+) (pb.Response, TransactionEvent, *txSimulation) {
+	divergence, restore := stub.checkEndorsements(uuid, args, execute)
+	defer restore()
+	if len(divergence) > 0 {
+		return stub.rejectEndorsements(uuid, divergence)
+	}
+	if stub.dryRun {
+		return stub.mockUncommittedTransaction(uuid, args, execute, false)
+	}
 	stub.args = args
+	delete(stub.events, uuid)
 	stub.MockTransactionStart(uuid)
-	res := stub.cc.Init(stub)
+	sim := newTxSimulation()
+	stub.simulation = sim
+	res := execute(stub)
+	stub.simulation = nil
+	tx := stub.transactionEvent(res, sim)
+	if res.Status < shim.ERRORTHRESHOLD {
+		stub.nextBlock()
+		stub.commitSimulation(sim)
+		stub.publishBlock(&BlockEvent{Number: stub.blockNumber, Transactions: []TransactionEvent{tx}})
+	}
 	stub.MockTransactionEnd(uuid)
-	return res
+	return res, tx, sim
 }
 
 // GetFunctionAndParameters Override this function from MockStub
@@ -144,16 +233,126 @@ func (stub *MockStubExtend) GetStringArgs() []string {
 
 // PutState writes the specified `value` and `key` into the ledger.
 func (stub *MockStubExtend) PutState(key string, value []byte) error {
+	if err := stub.keyValueRules.Validate(key, value); err != nil {
+		return err
+	}
+	// While a transaction is endorsed, its writes are kept aside until it is validated
+	if stub.simulation != nil {
+		if err := stub.simulation.checkWrite("PutState", fmt.Sprintf("%q", key)); err != nil {
+			return err
+		}
+		if err := stub.checkRichQueryWrite("PutState"); err != nil {
+			return err
+		}
+		stub.simulation.write(key, value)
+		return nil
+	}
+	return stub.commitState(key, value)
+}
+
+// DelState removes the specified `key` and its value from the ledger. With CouchDB, see
+// SetCouchDBConfiguration, the document is removed from the database; MockStub only removed
+// keys from its own map, which CouchDB mode does not read.
+func (stub *MockStubExtend) DelState(key string) error {
+	if err := stub.keyValueRules.Validate(key, nil); err != nil {
+		return err
+	}
+	if stub.simulation != nil {
+		if err := stub.simulation.checkWrite("DelState", fmt.Sprintf("%q", key)); err != nil {
+			return err
+		}
+		if err := stub.checkRichQueryWrite("DelState"); err != nil {
+			return err
+		}
+		stub.simulation.write(key, nil)
+		return nil
+	}
+	return stub.commitDelete(key)
+}
+
+// PutPrivateData writes a key of a private data collection. Like the other writes, the write
+// of a simulated transaction is recorded in its result and only applied when the transaction
+// is committed.
+func (stub *MockStubExtend) PutPrivateData(collection string, key string, value []byte) error {
+	if len(value) == 0 {
+		return stub.DelPrivateData(collection, key)
+	}
+	if err := stub.keyValueRules.Validate(key, value); err != nil {
+		return err
+	}
+	if sim := stub.simulation; sim != nil {
+		if err := sim.checkWrite("PutPrivateData", fmt.Sprintf("%s, %q", collection, key)); err != nil {
+			return err
+		}
+		if err := stub.checkRichQueryWrite("PutPrivateData"); err != nil {
+			return err
+		}
+		sim.writePrivate(collection, key, value)
+		return nil
+	}
+	return stub.MockStub.PutPrivateData(collection, key, value)
+}
+
+// DelPrivateData overrides the same function in MockStub that did not implement anything.
+func (stub *MockStubExtend) DelPrivateData(collection string, key string) error {
+	if err := stub.keyValueRules.Validate(key, nil); err != nil {
+		return err
+	}
+	if sim := stub.simulation; sim != nil {
+		if err := sim.checkWrite("DelPrivateData", fmt.Sprintf("%s, %q", collection, key)); err != nil {
+			return err
+		}
+		if err := stub.checkRichQueryWrite("DelPrivateData"); err != nil {
+			return err
+		}
+		sim.writePrivate(collection, key, nil)
+		return nil
+	}
+	delete(stub.PvtState[collection], key)
+	return nil
+}
+
+// commitState writes a key to the state database and moves its version forward
+func (stub *MockStubExtend) commitState(key string, value []byte) error {
+	if len(value) == 0 {
+		return stub.commitDelete(key)
+	}
+
+	var err error
 	// In case we are using CouchDB, we store the value document in the database
 	if stub.CouchDB {
-		return stub.DbHandler.SaveDocument(key, value)
+		err = stub.DbHandler.SaveDocument(key, value)
+	} else {
+		err = stub.putStateOriginal(key, value)
+	}
+	if err == nil {
+		stub.keyVersions[key] = version.NewHeight(stub.blockNumber, stub.txNum)
+		stub.recordHistory(key, value, false)
+	}
+	return err
+}
+
+// commitDelete removes a key from the state database
+func (stub *MockStubExtend) commitDelete(key string) error {
+	var err error
+	// In case we are using CouchDB, the document must be removed from the database
+	if stub.CouchDB {
+		err = stub.DbHandler.DeleteDocument(key)
+	} else {
+		err = stub.MockStub.DelState(key)
 	}
-	// Carry on
-	return stub.putStateOriginal(key, value)
+	if err == nil {
+		delete(stub.keyVersions, key)
+		stub.recordHistory(key, nil, true)
+	}
+	return err
 }
 
 // GetState retrieves the value for a given key from the ledger
 func (stub *MockStubExtend) GetState(key string) ([]byte, error) {
+	if stub.simulation != nil {
+		stub.simulation.read(key, stub.keyVersions[key])
+	}
 	// In case we are using CouchDB, we store the value document in the database
 	if stub.CouchDB {
 		return stub.DbHandler.ReadDocument(key)
@@ -219,8 +418,43 @@ func (stub *MockStubExtend) putStateOriginal(key string, value []byte) error {
 	return nil
 }
 
-// GetStateByPartialCompositeKey queries couchdb by range
+// GetStateByRange overrides the same function in MockStub to query CouchDB when it is used.
+// Like on a peer, the results are limited to the total query limit.
+func (stub *MockStubExtend) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	query := fmt.Sprintf("GetStateByRange(%q, %q)", startKey, endKey)
+	if !stub.CouchDB {
+		iterator, err := stub.MockStub.GetStateByRange(startKey, endKey)
+		if err != nil {
+			return nil, err
+		}
+		return stub.limitQuery(stub.recordRangeQuery(iterator, startKey, endKey), query), nil
+	}
+	rs, er := stub.DbHandler.QueryDocumentByRange(startKey, endKey)
+	if er != nil {
+		return nil, er
+	}
+	iterator, er := FromResultsIterator(rs)
+	if er != nil {
+		return nil, er
+	}
+	return stub.limitQuery(stub.recordRangeQuery(iterator, startKey, endKey), query), nil
+}
+
+// GetStateByPartialCompositeKey queries CouchDB by range, or the mock ledger map when
+// CouchDB is not configured, so that util tables can be tested in memory.
+// Like on a peer, the results are limited to the total query limit.
 func (stub *MockStubExtend) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	query := fmt.Sprintf("GetStateByPartialCompositeKey(%q, %q)", objectType, attributes)
+	// Without CouchDB the keys are kept in the mock ledger map
+	if !stub.CouchDB {
+		iterator, err := stub.MockStub.GetStateByPartialCompositeKey(objectType, attributes)
+		if err != nil {
+			return nil, err
+		}
+		startKey, _ := stub.CreateCompositeKey(objectType, attributes)
+		return stub.limitQuery(stub.recordRangeQuery(iterator, startKey, startKey+string(maxUnicodeRuneValue)), query), nil
+	}
+
 	startKey, _ := stub.CreateCompositeKey(objectType, attributes)
 	endKey := startKey + string(maxUnicodeRuneValue)
 
@@ -234,7 +468,7 @@ func (stub *MockStubExtend) GetStateByPartialCompositeKey(objectType string, att
 		return nil, er
 	}
 
-	return iterator, nil
+	return stub.limitQuery(stub.recordRangeQuery(iterator, startKey, endKey), query), nil
 }
 
 // GetStateByPartialCompositeKeyWithPagination queries couchdb with a partial compositekey and pagination information
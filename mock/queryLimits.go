@@ -0,0 +1,123 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package mock
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/spf13/viper"
+)
+
+// Default limits of a peer, used when core.yaml does not set them.
+const (
+	DefaultTotalQueryLimit    = 100000
+	DefaultInternalQueryLimit = 1000
+)
+
+// QueryLimits are the limits that a peer puts on the results of the range, partial composite
+// key and rich queries of a chaincode. A query that has more results than TotalQueryLimit is
+// truncated without any error, so chaincode that expects to see every result only breaks on
+// a peer holding enough data. Paginated queries are only bound by their page size.
+type QueryLimits struct {
+	TotalQueryLimit    int  // ledger.state.totalQueryLimit, 0 means no limit
+	InternalQueryLimit int  // ledger.state.couchDBConfig.internalQueryLimit, the size of the batches read from CouchDB
+	WarnOnTruncation   bool // log a warning, and add it to the result of the transaction, when a query is truncated
+}
+
+// DefaultQueryLimits returns the limits set in core.yaml, or the defaults of a peer.
+func DefaultQueryLimits() QueryLimits {
+	limits := QueryLimits{
+		TotalQueryLimit:    viper.GetInt("ledger.state.totalQueryLimit"),
+		InternalQueryLimit: viper.GetInt("ledger.state.couchDBConfig.internalQueryLimit"),
+	}
+	if limits.TotalQueryLimit <= 0 {
+		limits.TotalQueryLimit = DefaultTotalQueryLimit
+	}
+	if limits.InternalQueryLimit <= 0 {
+		limits.InternalQueryLimit = DefaultInternalQueryLimit
+	}
+	return limits
+}
+
+// SetQueryLimits replaces the limits applied to the queries of the chaincode.
+func (stub *MockStubExtend) SetQueryLimits(limits QueryLimits) {
+	stub.queryLimits = limits
+}
+
+// QueryLimits returns the limits applied to the queries of the chaincode.
+func (stub *MockStubExtend) QueryLimits() QueryLimits {
+	return stub.queryLimits
+}
+
+// limitQuery applies the total query limit to the results of a query
+func (stub *MockStubExtend) limitQuery(iterator shim.StateQueryIteratorInterface, query string) shim.StateQueryIteratorInterface {
+	if stub.queryLimits.TotalQueryLimit <= 0 {
+		return iterator
+	}
+	return &limitedQueryIterator{
+		StateQueryIteratorInterface: iterator,
+		remaining:                   stub.queryLimits.TotalQueryLimit,
+		truncated: func() {
+			if stub.queryLimits.WarnOnTruncation {
+				stub.warnf("%s was truncated to totalQueryLimit %d results", query, stub.queryLimits.TotalQueryLimit)
+			}
+		},
+	}
+}
+
+// warnf logs a warning about the current transaction and adds it to the result of the transaction
+func (stub *MockStubExtend) warnf(format string, args ...interface{}) {
+	warning := fmt.Sprintf(format, args...)
+	sim := stub.simulation
+	if sim == nil || !sim.endorsement {
+		mockLogger.Warningf("MockStub %s transaction %s: %s", stub.Name, stub.TxID, warning)
+	}
+	if sim != nil {
+		sim.warnings = append(sim.warnings, warning)
+	}
+}
+
+// limitedQueryIterator stops a query after a number of results
+type limitedQueryIterator struct {
+	shim.StateQueryIteratorInterface
+	remaining int
+	truncated func()
+}
+
+func (it *limitedQueryIterator) HasNext() bool {
+	if it.remaining > 0 {
+		return it.StateQueryIteratorInterface.HasNext()
+	}
+	if it.truncated != nil && it.StateQueryIteratorInterface.HasNext() {
+		it.truncated()
+	}
+	it.truncated = nil
+	return false
+}
+
+func (it *limitedQueryIterator) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("no more results, the query is limited to its first results")
+	}
+	it.remaining--
+	return it.StateQueryIteratorInterface.Next()
+}
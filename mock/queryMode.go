@@ -0,0 +1,87 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package mock
+
+import (
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// A query transaction is executed like on a peer that is only asked to evaluate it: it reads
+// the committed state and is never committed. Its writes and events would be silently lost on
+// a real network, so the mock reports them. Query transactions are run by ExecuteQuery,
+// QueryTransaction, MockQueryTransaction and EvaluateContractTransaction.
+
+// This is effectively a strongly typed enum declaration.
+type QueryWriteMode uint8
+
+const (
+	QUERY_REJECT_WRITES QueryWriteMode = 0 // writes and events fail with an error returned to the chaincode
+	QUERY_REPORT_WRITES QueryWriteMode = 1 // writes and events succeed, are discarded and reported
+)
+
+// SetQueryWriteMode sets how the writes and events of query transactions are handled. By
+// default they are rejected.
+func (stub *MockStubExtend) SetQueryWriteMode(mode QueryWriteMode) {
+	stub.queryWrites = mode
+}
+
+// SetDryRun makes MockInvoke, MockInit and the helpers built on them execute transactions
+// without committing them: nothing is written to the state, the history or the blocks, and
+// the result of the transaction reports what would have been written. Transactions do not see
+// their own writes either way. MockConcurrentInvoke endorses its transactions without
+// validating nor committing them.
+func (stub *MockStubExtend) SetDryRun(dryRun bool) {
+	stub.dryRun = dryRun
+}
+
+// ExecuteQuery executes a query transaction with the given context and returns everything
+// known about it. The writes and events attempted by the chaincode are listed in Violations.
+func (stub *MockStubExtend) ExecuteQuery(args [][]byte, options InvokeOptions) *InvokeResult {
+	return stub.executeTransaction(args, options, func(txID string) (pb.Response, TransactionEvent, *txSimulation) {
+		return stub.mockUncommittedTransaction(txID, args, stub.cc.Invoke, true)
+	})
+}
+
+// mockUncommittedTransaction executes a transaction whose writes are kept in its read/write
+// set only. It is a query when query is set, otherwise a dry run.
+func (stub *MockStubExtend) mockUncommittedTransaction(
+	uuid string,
+	args [][]byte,
+	execute func(shim.ChaincodeStubInterface) pb.Response,
+	query bool,
+) (pb.Response, TransactionEvent, *txSimulation) {
+	stub.args = args
+	delete(stub.events, uuid)
+	stub.MockTransactionStart(uuid)
+	sim := newTxSimulation()
+	sim.uncommitted, sim.query, sim.queryWrites = true, query, stub.queryWrites
+	stub.simulation = sim
+	res := execute(stub)
+	tx := stub.transactionEvent(res, sim)
+	stub.simulation = nil
+	stub.MockTransactionEnd(uuid)
+	if query {
+		for _, violation := range sim.violations {
+			mockLogger.Warningf("MockStub %s query transaction %s attempted %s", stub.Name, uuid, violation)
+		}
+	}
+	return res, tx, sim
+}
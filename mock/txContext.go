@@ -0,0 +1,289 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package mock
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric-protos-go/common"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// MockClock gives transactions their timestamp, so that logic depending on the transaction
+// time can be tested deterministically. Every transaction started while a clock is set on the
+// stub gets the current time of the clock, then the clock moves forward by its step.
+type MockClock struct {
+	lock sync.Mutex
+	now  time.Time
+	step time.Duration
+}
+
+// NewMockClock creates a clock that starts at start and moves forward by step after every
+// transaction. A step of 0 gives the same timestamp to all transactions until the clock is
+// moved with Set or Advance.
+func NewMockClock(start time.Time, step time.Duration) *MockClock {
+	return &MockClock{now: start.UTC(), step: step}
+}
+
+// Now returns the timestamp that the next transaction gets.
+func (clock *MockClock) Now() time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	return clock.now
+}
+
+// Set moves the clock to t, possibly backwards.
+func (clock *MockClock) Set(t time.Time) {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	clock.now = t.UTC()
+}
+
+// Advance moves the clock forward by d and returns the new time.
+func (clock *MockClock) Advance(d time.Duration) time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	clock.now = clock.now.Add(d)
+	return clock.now
+}
+
+// tick returns the timestamp of a new transaction and moves the clock by its step
+func (clock *MockClock) tick() time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	now := clock.now
+	clock.now = clock.now.Add(clock.step)
+	return now
+}
+
+// InvokeOptions sets the context of one transaction of MockInvokeWithOptions or
+// MockInitWithOptions. Zero fields keep the values of the stub.
+type InvokeOptions struct {
+	TxID        string            // generated like the other transactions when empty
+	Timestamp   time.Time         // taken from the clock of the stub, or the system time, when zero
+	ChannelID   string            // stub.ChannelID when empty
+	Creator     []byte            // stub.Creator when nil
+	Transient   map[string][]byte // transient data of the proposal, stub.TransientMap when nil
+	Decorations map[string][]byte // decorations added by the peer
+}
+
+// SetClock makes the stub take the timestamp of every transaction from clock. Pass nil to go
+// back to the system time.
+func (stub *MockStubExtend) SetClock(clock *MockClock) {
+	stub.clock = clock
+}
+
+// Clock returns the clock set on the stub, if any.
+func (stub *MockStubExtend) Clock() *MockClock {
+	return stub.clock
+}
+
+// SeedTxIDs makes the transaction IDs generated by the stub deterministic. Like on a client,
+// every transaction gets a nonce and its ID is the SHA-256 of the nonce and the creator; the
+// nonces are drawn from a random source seeded with seed instead of a secure one.
+func (stub *MockStubExtend) SeedTxIDs(seed int64) {
+	stub.nonces = mathrand.New(mathrand.NewSource(seed))
+}
+
+// newTxID generates the ID of a new transaction and remembers its nonce for the proposal
+func (stub *MockStubExtend) newTxID() string {
+	nonce := stub.newNonce()
+	digest := sha256.Sum256(append(append([]byte(nil), nonce...), stub.Creator...))
+	txID := hex.EncodeToString(digest[:])
+	stub.pendingNonces[txID] = nonce
+	return txID
+}
+
+func (stub *MockStubExtend) newNonce() []byte {
+	nonce := make([]byte, 24)
+	var source io.Reader = rand.Reader
+	if stub.nonces != nil {
+		source = stub.nonces
+	}
+	if _, err := io.ReadFull(source, nonce); err != nil {
+		panic(fmt.Errorf("cannot generate a nonce: %v", err))
+	}
+	return nonce
+}
+
+// MockInvokeWithOptions invokes the chaincode in a transaction with the given context and
+// commits it as a block of its own, like MockInvoke. It returns the ID of the transaction.
+func (stub *MockStubExtend) MockInvokeWithOptions(args [][]byte, options InvokeOptions) (string, pb.Response) {
+	var res pb.Response
+	txID := stub.mockTransactionWithOptions(options, func(txID string) {
+		res = stub.MockInvoke(txID, args)
+	})
+	return txID, res
+}
+
+// MockInitWithOptions initialises the chaincode in a transaction with the given context, like MockInit.
+func (stub *MockStubExtend) MockInitWithOptions(args [][]byte, options InvokeOptions) (string, pb.Response) {
+	var res pb.Response
+	txID := stub.mockTransactionWithOptions(options, func(txID string) {
+		res = stub.MockInit(txID, args)
+	})
+	return txID, res
+}
+
+// mockTransactionWithOptions sets the context of a transaction, runs it and restores the
+// context of the stub. It returns the ID of the transaction.
+func (stub *MockStubExtend) mockTransactionWithOptions(options InvokeOptions, run func(txID string)) string {
+	channelID, creator, transient, decorations := stub.ChannelID, stub.Creator, stub.TransientMap, stub.Decorations
+	defer func() {
+		stub.ChannelID, stub.Creator, stub.TransientMap, stub.Decorations = channelID, creator, transient, decorations
+		stub.pendingTimestamp = time.Time{}
+	}()
+
+	if options.ChannelID != "" {
+		stub.ChannelID = options.ChannelID
+	}
+	if options.Creator != nil {
+		stub.Creator = options.Creator
+	}
+	if options.Transient != nil {
+		stub.TransientMap = options.Transient
+	}
+	if options.Decorations != nil {
+		stub.Decorations = options.Decorations
+	}
+	stub.pendingTimestamp = options.Timestamp
+
+	txID := options.TxID
+	if txID == "" {
+		txID = stub.newTxID()
+	}
+	run(txID)
+	return txID
+}
+
+// MockTransactionStart overrides the same function in MockStub. The transaction takes its
+// timestamp from the clock of the stub, if any, and gets a signed proposal built from its
+// ID, timestamp, channel, creator, arguments and transient data.
+func (stub *MockStubExtend) MockTransactionStart(txid string) {
+	stub.MockStub.MockTransactionStart(txid)
+	switch {
+	case !stub.pendingTimestamp.IsZero():
+		stub.TxTimestamp, _ = ptypes.TimestampProto(stub.pendingTimestamp)
+	case stub.clock != nil:
+		stub.TxTimestamp, _ = ptypes.TimestampProto(stub.clock.tick())
+	}
+
+	nonce, found := stub.pendingNonces[txid]
+	if found {
+		delete(stub.pendingNonces, txid)
+	} else {
+		nonce = stub.newNonce()
+	}
+	stub.nonce = nonce
+	if err := stub.buildProposal(); err != nil {
+		mockLogger.Errorf("MockStub %s failed to build the proposal of transaction %s: %+v", stub.Name, txid, err)
+	}
+}
+
+// MockTransactionEnd overrides the same function in MockStub. The nonce generated for the
+// transaction is forgotten, even if the transaction was never started.
+func (stub *MockStubExtend) MockTransactionEnd(uuid string) {
+	stub.MockStub.MockTransactionEnd(uuid)
+	delete(stub.pendingNonces, uuid)
+	stub.signedProposal, stub.binding, stub.nonce = nil, nil, nil
+}
+
+// GetSignedProposal overrides the same function in MockStub that returned an empty proposal.
+func (stub *MockStubExtend) GetSignedProposal() (*pb.SignedProposal, error) {
+	return stub.signedProposal, nil
+}
+
+// GetBinding overrides the same function in MockStub that did not implement anything. Like
+// on a peer, the binding is the SHA-256 of the nonce, the creator and the epoch of the proposal.
+func (stub *MockStubExtend) GetBinding() ([]byte, error) {
+	return stub.binding, nil
+}
+
+// SetTransient overrides the same function in MockStub so that the transient data is part of
+// the signed proposal of the transaction.
+func (stub *MockStubExtend) SetTransient(tMap map[string][]byte) error {
+	if stub.signedProposal == nil {
+		return fmt.Errorf("signedProposal is not initialized")
+	}
+	stub.TransientMap = tMap
+	return stub.buildProposal()
+}
+
+// buildProposal builds the signed proposal and the binding of the current transaction. The
+// proposal is not signed, there is no key to sign it with.
+func (stub *MockStubExtend) buildProposal() error {
+	chaincodeID := &pb.ChaincodeID{Name: stub.Name}
+	extension, err := proto.Marshal(&pb.ChaincodeHeaderExtension{ChaincodeId: chaincodeID})
+	if err != nil {
+		return err
+	}
+	channelHeader, err := proto.Marshal(&common.ChannelHeader{
+		Type:      int32(common.HeaderType_ENDORSER_TRANSACTION),
+		TxId:      stub.TxID,
+		Timestamp: stub.TxTimestamp,
+		ChannelId: stub.ChannelID,
+		Extension: extension,
+	})
+	if err != nil {
+		return err
+	}
+	signatureHeader, err := proto.Marshal(&common.SignatureHeader{Nonce: stub.nonce, Creator: stub.Creator})
+	if err != nil {
+		return err
+	}
+	header, err := proto.Marshal(&common.Header{ChannelHeader: channelHeader, SignatureHeader: signatureHeader})
+	if err != nil {
+		return err
+	}
+	input, err := proto.Marshal(&pb.ChaincodeInvocationSpec{ChaincodeSpec: &pb.ChaincodeSpec{
+		Type:        pb.ChaincodeSpec_GOLANG,
+		ChaincodeId: chaincodeID,
+		Input:       &pb.ChaincodeInput{Args: stub.args},
+	}})
+	if err != nil {
+		return err
+	}
+	payload, err := proto.Marshal(&pb.ChaincodeProposalPayload{Input: input, TransientMap: stub.TransientMap})
+	if err != nil {
+		return err
+	}
+	proposal, err := proto.Marshal(&pb.Proposal{Header: header, Payload: payload})
+	if err != nil {
+		return err
+	}
+	stub.signedProposal = &pb.SignedProposal{ProposalBytes: proposal}
+
+	// The epoch is always 0, like in the proposals built by the SDKs
+	epoch := make([]byte, 8)
+	binary.LittleEndian.PutUint64(epoch, 0)
+	digest := sha256.Sum256(append(append(append([]byte(nil), stub.nonce...), stub.Creator...), epoch...))
+	stub.binding = digest[:]
+	return nil
+}
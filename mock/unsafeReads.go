@@ -0,0 +1,189 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package mock
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/version"
+)
+
+// When a transaction is validated, a peer checks that the keys it read and the results of its
+// range and partial composite key queries have not changed since it was endorsed. The results
+// of rich queries are not checked again, so a transaction that writes on the basis of a rich
+// query can commit decisions taken on stale data.
+//
+// MockConcurrentInvoke re-executes the range queries of every transaction at validation time
+// and invalidates the transaction with PHANTOM_READ_CONFLICT when the results differ. Rich
+// queries in transactions that write are handled according to the RichQueryPolicy of the stub.
+
+// This is effectively a strongly typed enum declaration.
+type RichQueryPolicy uint8
+
+const (
+	RICH_QUERY_WARN   RichQueryPolicy = 0 // log a warning and add it to the result of the transaction
+	RICH_QUERY_REJECT RichQueryPolicy = 1 // fail the rich query or the write with an error returned to the chaincode
+	RICH_QUERY_IGNORE RichQueryPolicy = 2 // allow rich queries in transactions that write
+)
+
+// SetRichQueryPolicy sets how the stub handles transactions that both run rich queries and write.
+// By default a warning is reported.
+func (stub *MockStubExtend) SetRichQueryPolicy(policy RichQueryPolicy) {
+	stub.richQueryPolicy = policy
+}
+
+// rangeQuery is a range query run by a transaction with the keys and versions it returned
+type rangeQuery struct {
+	startKey  string
+	endKey    string
+	results   []KVRead
+	exhausted bool // the transaction read every result
+}
+
+// checkRichQuery records a rich query of the current transaction, which is unsafe if the
+// transaction has already written
+func (stub *MockStubExtend) checkRichQuery(query string) error {
+	sim := stub.simulation
+	if sim == nil || sim.query {
+		return nil
+	}
+	sim.richQueries = append(sim.richQueries, query)
+	if len(sim.writes) == 0 && len(sim.privateWrites) == 0 {
+		return nil
+	}
+	return stub.unsafeRichQuery(sim, fmt.Sprintf("GetQueryResult(%s)", query))
+}
+
+// checkRichQueryWrite checks a write of the current transaction, which is unsafe if the
+// transaction has run rich queries
+func (stub *MockStubExtend) checkRichQueryWrite(operation string) error {
+	sim := stub.simulation
+	if sim == nil || sim.query || len(sim.richQueries) == 0 {
+		return nil
+	}
+	return stub.unsafeRichQuery(sim, operation)
+}
+
+func (stub *MockStubExtend) unsafeRichQuery(sim *txSimulation, operation string) error {
+	message := fmt.Sprintf("%s in a transaction that both writes and runs the rich query %s, whose results are not validated at commit",
+		operation, sim.richQueries[0])
+	switch stub.richQueryPolicy {
+	case RICH_QUERY_REJECT:
+		return fmt.Errorf("%s is not allowed", message)
+	case RICH_QUERY_WARN:
+		if !sim.richQueryWarned {
+			sim.richQueryWarned = true
+			stub.warnf("%s", message)
+		}
+	}
+	return nil
+}
+
+// recordRangeQuery records the results of a range query read by the current transaction
+func (stub *MockStubExtend) recordRangeQuery(iterator shim.StateQueryIteratorInterface, startKey string, endKey string) shim.StateQueryIteratorInterface {
+	sim := stub.simulation
+	if sim == nil {
+		return iterator
+	}
+	query := &rangeQuery{startKey: startKey, endKey: endKey}
+	sim.rangeQueries = append(sim.rangeQueries, query)
+	return &rangeQueryIterator{StateQueryIteratorInterface: iterator, query: query, versions: stub.keyVersions}
+}
+
+// rangeQueryIterator records the results of a range query as they are read
+type rangeQueryIterator struct {
+	shim.StateQueryIteratorInterface
+	query    *rangeQuery
+	versions map[string]*version.Height
+}
+
+func (it *rangeQueryIterator) HasNext() bool {
+	hasNext := it.StateQueryIteratorInterface.HasNext()
+	if !hasNext {
+		it.query.exhausted = true
+	}
+	return hasNext
+}
+
+func (it *rangeQueryIterator) Next() (*queryresult.KV, error) {
+	kv, err := it.StateQueryIteratorInterface.Next()
+	if err == nil {
+		it.query.results = append(it.query.results, KVRead{Key: kv.Key, Version: it.versions[kv.Key]})
+	}
+	return kv, err
+}
+
+// validateRangeQueries re-executes the range queries of a transaction against the committed
+// state and reports whether they still return the same keys at the same versions. Like on a
+// peer, a query that was not read to the end is only checked up to its last result.
+func (stub *MockStubExtend) validateRangeQueries(sim *txSimulation) bool {
+	for _, query := range sim.rangeQueries {
+		current, err := stub.committedRange(query.startKey, query.endKey)
+		if err != nil {
+			mockLogger.Errorf("MockStub %s failed to re-execute a range query: %+v", stub.Name, err)
+			return false
+		}
+		if !query.exhausted {
+			if len(current) < len(query.results) {
+				return false
+			}
+			current = current[:len(query.results)]
+		}
+		if len(current) != len(query.results) {
+			return false
+		}
+		for i, read := range query.results {
+			if current[i] != read.Key || !version.AreSame(read.Version, stub.keyVersions[read.Key]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// committedRange lists the committed keys in [startKey, endKey)
+func (stub *MockStubExtend) committedRange(startKey string, endKey string) ([]string, error) {
+	var iterator shim.StateQueryIteratorInterface
+	if stub.CouchDB {
+		rs, err := stub.DbHandler.QueryDocumentByRange(startKey, endKey)
+		if err != nil {
+			return nil, err
+		}
+		if iterator, err = FromResultsIterator(rs); err != nil {
+			return nil, err
+		}
+	} else {
+		iterator = shimtest.NewMockStateRangeQueryIterator(stub.MockStub, startKey, endKey)
+	}
+	defer iterator.Close()
+
+	keys := make([]string, 0)
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, kv.Key)
+	}
+	return keys, nil
+}
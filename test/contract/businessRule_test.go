@@ -0,0 +1,127 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package contract
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Akachain/akc-go-sdk-v2/common"
+	"github.com/Akachain/akc-go-sdk-v2/util"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"gotest.tools/assert"
+)
+
+type Loan struct {
+	ID       string   `json:"ID"`
+	Amount   float64  `json:"Amount"`
+	Borrower Borrower `json:"Borrower"`
+}
+
+type Borrower struct {
+	Name    string `json:"Name"`
+	Country string `json:"Country"`
+}
+
+const loanTable = "LOAN"
+
+func TestBusinessRules(t *testing.T) {
+	registerTableSchema(t, loanTable, util.TableSchema{Rules: true})
+	stub := setupMemoryMock()
+	stub.MockTransactionStart("tx1")
+	defer stub.MockTransactionEnd("tx1")
+
+	maxAmount := util.BusinessRule{
+		Table:      loanTable,
+		Name:       "MaxAmount",
+		Expression: "Amount > 0 && Amount <= MaxAmount",
+		Parameters: map[string]util.RuleParameter{"MaxAmount": {Type: util.RULE_NUMBER, Value: json.RawMessage("1000")}},
+		Message:    "the amount is out of bounds",
+	}
+
+	// Rules cannot be changed until administrators are configured
+	util.SetRuleAdminCheck(nil)
+	_, err := util.SaveBusinessRule(stub, maxAmount)
+	assert.ErrorContains(t, err, "SetRuleAdminCheck")
+	util.SetRuleAdminCheck(func(stub shim.ChaincodeStubInterface) error { return nil })
+
+	ruleJSON, _ := json.Marshal(&maxAmount)
+	res := util.BusinessRuleAdminInvoke(stub, "SaveBusinessRule", []string{string(ruleJSON)})
+	assert.Equal(t, int32(common.OK), res.Status)
+	_, err = util.SaveBusinessRule(stub, util.BusinessRule{Table: loanTable, Name: "Country", Expression: "[Borrower.Country] != Banned"})
+	assert.NilError(t, err)
+	_, err = util.SaveBusinessRule(stub, util.BusinessRule{Table: loanTable, Name: "Broken", Expression: "Amount >"})
+	assert.ErrorContains(t, err, "does not compile")
+
+	// The second rule references a parameter that is not declared yet: every violation is reported
+	err = util.CreateData(stub, loanTable, []string{"l1"}, &Loan{ID: "l1", Amount: 5000, Borrower: Borrower{Country: "XX"}})
+	assert.Assert(t, util.IsRuleViolation(err))
+	res = util.RespondRuleViolation(err)
+	assert.Equal(t, int32(common.ERROR), res.Status)
+	assert.Assert(t, strings.Contains(res.Message, `"status":"AKC0019"`))
+	assert.Assert(t, strings.Contains(res.Message, `"Values":{"Amount":5000,"MaxAmount":1000}`))
+
+	country := util.BusinessRule{
+		Table:      loanTable,
+		Name:       "Country",
+		Expression: "[Borrower.Country] != Banned",
+		Parameters: map[string]util.RuleParameter{"Banned": {Type: util.RULE_STRING, Value: json.RawMessage(`"XX"`)}},
+	}
+	saved, err := util.SaveBusinessRule(stub, country)
+	assert.NilError(t, err)
+	assert.Equal(t, uint64(2), saved.Version)
+	previous, found, err := util.GetBusinessRuleVersion(stub, loanTable, "Country", 1)
+	assert.NilError(t, err)
+	assert.Assert(t, found)
+	assert.Equal(t, 0, len(previous.Parameters))
+
+	assert.NilError(t, util.CreateData(stub, loanTable, []string{"l2"}, &Loan{ID: "l2", Amount: 500, Borrower: Borrower{Country: "VN"}}))
+
+	// Disabled rules are no longer evaluated
+	res = util.BusinessRuleAdminInvoke(stub, "DisableBusinessRule", []string{loanTable, "MaxAmount"})
+	assert.Equal(t, int32(common.OK), res.Status)
+	assert.NilError(t, util.CreateData(stub, loanTable, []string{"l3"}, &Loan{ID: "l3", Amount: 5000, Borrower: Borrower{Country: "VN"}}))
+}
+
+func TestBusinessRulesOnRestore(t *testing.T) {
+	registerTableSchema(t, "SOFT_LOAN", util.TableSchema{Rules: true, SoftDelete: true})
+	util.SetRuleAdminCheck(func(stub shim.ChaincodeStubInterface) error { return nil })
+	stub := setupMemoryMock()
+	stub.MockTransactionStart("tx1")
+	defer stub.MockTransactionEnd("tx1")
+
+	assert.NilError(t, util.CreateData(stub, "SOFT_LOAN", []string{"l1"}, &Loan{ID: "l1", Amount: 5000}))
+	_, err := util.DeleteTableRow(stub, "SOFT_LOAN", []string{"l1"}, nil, util.FAIL_IF_MISSING)
+	assert.NilError(t, err)
+
+	// A rule saved after the delete does not prevent restoring the row as it was
+	_, err = util.SaveBusinessRule(stub, util.BusinessRule{Table: "SOFT_LOAN", Name: "MaxAmount", Expression: "Amount <= 1000"})
+	assert.NilError(t, err)
+	assert.NilError(t, util.RestoreTableRow(stub, "SOFT_LOAN", []string{"l1"}))
+	var loan Loan
+	_, err = util.GetTableRow(stub, "SOFT_LOAN", []string{"l1"}, &loan, util.FAIL_IF_MISSING)
+	assert.NilError(t, err)
+	assert.Equal(t, float64(5000), loan.Amount)
+
+	// but it applies to the next update
+	err = util.UpdateTableRow(stub, "SOFT_LOAN", []string{"l1"}, &Loan{ID: "l1", Amount: 6000})
+	assert.Assert(t, util.IsRuleViolation(err))
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package contract
+
+import (
+	"testing"
+
+	"github.com/Akachain/akc-go-sdk-v2/util"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"gotest.tools/assert"
+)
+
+// lastEvent drains the events set on the mock and returns the last one, the only one a peer keeps.
+func lastEvent(events chan *pb.ChaincodeEvent) *pb.ChaincodeEvent {
+	var last *pb.ChaincodeEvent
+	for {
+		select {
+		case event := <-events:
+			last = event
+		default:
+			return last
+		}
+	}
+}
+
+func TestChangeEvents(t *testing.T) {
+	registerTableSchema(t, "PARCEL", util.TableSchema{Events: util.EVENT_DIFF})
+	stub := setupMemoryMock()
+
+	stub.MockTransactionStart("tx1")
+	assert.NilError(t, util.CreateData(stub, "PARCEL", []string{"p1"}, &Parcel{Code: "AAAAAA"}))
+	assert.NilError(t, util.CreateData(stub, "PARCEL", []string{"p2"}, &Parcel{Code: "BBBBBB"}))
+	assert.NilError(t, util.UpdateExistingData(stub, "PARCEL", []string{"p1"}, &Parcel{Code: "CCCCCC"}))
+	// Writes do not set the event, the chaincode flushes the changes once
+	assert.Assert(t, lastEvent(stub.ChaincodeEventsChannel) == nil)
+	assert.NilError(t, util.FlushChangeEvents(stub))
+	stub.MockTransactionEnd("tx1")
+
+	event := lastEvent(stub.ChaincodeEventsChannel)
+	assert.Equal(t, util.ChangeEventName, event.EventName)
+	batch, err := util.DecodeChangeEvents(event.Payload)
+	assert.NilError(t, err)
+	assert.Equal(t, "tx1", batch.TxID)
+	changes := batch.ChangesOf("PARCEL")
+	assert.Equal(t, 2, len(changes))
+	assert.Equal(t, util.AUDIT_INSERT, changes[0].Operation)
+	var parcel Parcel
+	_, err = changes[0].DecodeValue(&parcel)
+	assert.NilError(t, err)
+	assert.Equal(t, "CCCCCC", parcel.Code)
+
+	stub.MockTransactionStart("tx2")
+	_, err = util.DeleteTableRow(stub, "PARCEL", []string{"p2"}, nil, util.FAIL_IF_MISSING)
+	assert.NilError(t, err)
+	assert.NilError(t, util.FlushChangeEvents(stub))
+	stub.MockTransactionEnd("tx2")
+
+	batch, err = util.DecodeChangeEvents(lastEvent(stub.ChaincodeEventsChannel).Payload)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(batch.Changes))
+	assert.Equal(t, util.AUDIT_DELETE, batch.Changes[0].Operation)
+	found, err := batch.Changes[0].DecodeValue(&parcel)
+	assert.NilError(t, err)
+	assert.Assert(t, !found)
+	assert.Equal(t, "Code", batch.Changes[0].Changes[0].Field)
+}
+
+func TestChangeEventsOfUpdates(t *testing.T) {
+	registerTableSchema(t, "SOFT_PARCEL", util.TableSchema{Events: util.EVENT_DIFF, SoftDelete: true})
+	stub := setupMemoryMock()
+
+	stub.MockTransactionStart("tx1")
+	assert.NilError(t, util.CreateData(stub, "SOFT_PARCEL", []string{"p1"}, &Parcel{Code: "AAAAAA"}))
+	assert.NilError(t, util.FlushChangeEvents(stub))
+	stub.MockTransactionEnd("tx1")
+	lastEvent(stub.ChaincodeEventsChannel)
+
+	stub.MockTransactionStart("tx2")
+	assert.NilError(t, util.UpdateExistingData(stub, "SOFT_PARCEL", []string{"p1"}, &Parcel{Code: "BBBBBB"}))
+	assert.NilError(t, util.FlushChangeEvents(stub))
+	stub.MockTransactionEnd("tx2")
+	batch, err := util.DecodeChangeEvents(lastEvent(stub.ChaincodeEventsChannel).Payload)
+	assert.NilError(t, err)
+	assert.Equal(t, util.AUDIT_UPDATE, batch.Changes[0].Operation)
+	assert.Equal(t, 1, len(batch.Changes[0].Changes))
+
+	// A soft deleted row carries no value
+	stub.MockTransactionStart("tx3")
+	_, err = util.DeleteTableRow(stub, "SOFT_PARCEL", []string{"p1"}, nil, util.FAIL_IF_MISSING)
+	assert.NilError(t, err)
+	assert.NilError(t, util.FlushChangeEvents(stub))
+	stub.MockTransactionEnd("tx3")
+	batch, err = util.DecodeChangeEvents(lastEvent(stub.ChaincodeEventsChannel).Payload)
+	assert.NilError(t, err)
+	assert.Equal(t, util.AUDIT_DELETE, batch.Changes[0].Operation)
+	found, err := batch.Changes[0].DecodeValue(&Parcel{})
+	assert.NilError(t, err)
+	assert.Assert(t, !found)
+}
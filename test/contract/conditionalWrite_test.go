@@ -0,0 +1,89 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package contract
+
+import (
+	"testing"
+
+	"github.com/Akachain/akc-go-sdk-v2/mock"
+	"github.com/Akachain/akc-go-sdk-v2/util"
+	"gotest.tools/assert"
+)
+
+func TestConditionalWrites(t *testing.T) {
+	stub := setupMemoryMock()
+	stub.MockTransactionStart("tx1")
+	defer stub.MockTransactionEnd("tx1")
+
+	assert.NilError(t, util.CreateData(stub, orderTable, []string{"o3"}, &Order{ID: "o3", Status: "PAID", Items: []string{"a"}}))
+
+	// Only pending orders can be cancelled
+	pending := []util.FieldPredicate{{Field: "Status", Operator: util.FIELD_EQUALS, Value: "PENDING"}}
+	err := util.UpdateTableRowIf(stub, orderTable, []string{"o3"}, &Order{ID: "o3", Status: "CANCELLED"}, pending)
+	assert.Assert(t, util.IsPreconditionFailed(err))
+	assert.ErrorContains(t, err, `Status is "PAID"`)
+
+	paid := []util.FieldPredicate{
+		{Field: "Status", Operator: util.FIELD_IN, Value: []string{"PAID", "SHIPPED"}},
+		{Field: "Items", Operator: util.FIELD_EXISTS},
+		{Field: "Tags", Operator: util.FIELD_MISSING},
+	}
+	assert.NilError(t, util.UpdateTableRowIf(stub, orderTable, []string{"o3"}, &Order{ID: "o3", Status: "SHIPPED"}, paid))
+
+	err = util.DeleteTableRowIf(stub, orderTable, []string{"o3"}, pending, nil)
+	assert.Assert(t, util.IsPreconditionFailed(err))
+	shipped := []util.FieldPredicate{{Field: "Status", Operator: util.FIELD_GREATER_OR_EQUAL, Value: "SHIPPED"}}
+	assert.NilError(t, util.DeleteTableRowIf(stub, orderTable, []string{"o3"}, shipped, nil))
+}
+
+// readCountingStub counts the reads of every key
+type readCountingStub struct {
+	*mock.MockStubExtend
+	reads map[string]int
+}
+
+func (stub *readCountingStub) GetState(key string) ([]byte, error) {
+	stub.reads[key]++
+	return stub.MockStubExtend.GetState(key)
+}
+
+func TestConditionalWritesReadOnce(t *testing.T) {
+	registerTableSchema(t, "AUDITED_ORDER", util.TableSchema{Audit: util.AUDIT_DIFF})
+	stub := &readCountingStub{MockStubExtend: setupMemoryMock(), reads: make(map[string]int)}
+	stub.MockTransactionStart("tx1")
+	defer stub.MockTransactionEnd("tx1")
+	key, _ := stub.CreateCompositeKey("AUDITED_ORDER", []string{"o1"})
+	assert.NilError(t, util.CreateData(stub, "AUDITED_ORDER", []string{"o1"}, &Order{ID: "o1", Status: "PAID"}))
+
+	// The row checked against the predicates is the one replaced, it is not read again
+	paid := []util.FieldPredicate{{Field: "Status", Operator: util.FIELD_EQUALS, Value: "PAID"}}
+	stub.reads[key] = 0
+	assert.NilError(t, util.UpdateTableRowIf(stub, "AUDITED_ORDER", []string{"o1"}, &Order{ID: "o1", Status: "SHIPPED"}, paid))
+	assert.Equal(t, 1, stub.reads[key])
+
+	shipped := []util.FieldPredicate{{Field: "Status", Operator: util.FIELD_EQUALS, Value: "SHIPPED"}}
+	var deleted Order
+	stub.reads[key] = 0
+	assert.NilError(t, util.DeleteTableRowIf(stub, "AUDITED_ORDER", []string{"o1"}, shipped, &deleted))
+	assert.Equal(t, 1, stub.reads[key])
+	assert.Equal(t, "SHIPPED", deleted.Status)
+	row, _ := stub.GetState(key)
+	assert.Assert(t, row == nil)
+}
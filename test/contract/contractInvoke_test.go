@@ -0,0 +1,114 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package contract
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Akachain/akc-go-sdk-v2/mock"
+	"github.com/Akachain/akc-go-sdk-v2/util"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"gotest.tools/assert"
+)
+
+// InventoryContract keeps items in a util table, under the "inventory" namespace.
+type InventoryContract struct {
+	contractapi.Contract
+}
+
+type Item struct {
+	ID       string    `json:"ID"`
+	Quantity int       `json:"Quantity"`
+	Expiry   time.Time `json:"Expiry"`
+}
+
+func (c *InventoryContract) AddItem(ctx contractapi.TransactionContextInterface, item Item) error {
+	return util.CreateData(ctx.GetStub(), "ITEM", []string{item.ID}, &item)
+}
+
+func (c *InventoryContract) GetItem(ctx contractapi.TransactionContextInterface, id string) (*Item, error) {
+	var item Item
+	if _, err := util.GetTableRow(ctx.GetStub(), "ITEM", []string{id}, &item, util.FAIL_IF_MISSING); err != nil {
+		return nil, errors.New("item " + id + " does not exist")
+	}
+	return &item, nil
+}
+
+func (c *InventoryContract) CountAbove(ctx contractapi.TransactionContextInterface, minimum int, ids []string) (int, error) {
+	count := 0
+	for _, id := range ids {
+		item, err := c.GetItem(ctx, id)
+		if err == nil && item.Quantity > minimum {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func TestContractTransactions(t *testing.T) {
+	inventory := &InventoryContract{}
+	inventory.Name = "inventory"
+	chaincode, err := contractapi.NewChaincode(new(SampleContract), inventory)
+	assert.NilError(t, err)
+	stub := mock.NewMockStubExtend(shimtest.NewMockStub("inventory", chaincode), chaincode, ".")
+
+	expiry := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	_, err = stub.SubmitContractTransaction("inventory:AddItem", nil, Item{ID: "i1", Quantity: 5, Expiry: expiry})
+	assert.NilError(t, err)
+	_, err = stub.SubmitContractTransaction("inventory:AddItem", nil, Item{ID: "i2", Quantity: 1})
+	assert.NilError(t, err)
+
+	var item Item
+	_, err = stub.EvaluateContractTransaction("inventory:GetItem", &item, "i1")
+	assert.NilError(t, err)
+	assert.Equal(t, 5, item.Quantity)
+	assert.Assert(t, item.Expiry.Equal(expiry))
+
+	var count int
+	_, err = stub.EvaluateContractTransaction("inventory:CountAbove", &count, 2, []string{"i1", "i2"})
+	assert.NilError(t, err)
+	assert.Equal(t, 1, count)
+
+	// Errors of the transaction function are typed
+	result, err := stub.EvaluateContractTransaction("inventory:GetItem", &item, "missing")
+	assert.Assert(t, mock.IsContractError(err))
+	assert.Equal(t, "item missing does not exist", err.(*mock.ContractError).Message)
+	assert.Assert(t, !result.OK())
+
+	// Evaluated functions must not write
+	_, err = stub.EvaluateContractTransaction("inventory:AddItem", nil, Item{ID: "i3"})
+	assert.Assert(t, mock.IsContractError(err))
+	assert.ErrorContains(t, err, "not allowed in a query transaction")
+
+	// Calls that do not match the metadata are rejected before running a transaction
+	blocks := len(stub.GetBlocks())
+	_, err = stub.SubmitContractTransaction("inventory:AddItem", nil)
+	assert.ErrorContains(t, err, "takes 1 arguments")
+	_, err = stub.SubmitContractTransaction("inventory:RemoveItem", nil, "i1")
+	assert.ErrorContains(t, err, "not part of contract inventory")
+	_, err = stub.SubmitContractTransaction("inventory:additem", nil, Item{ID: "i4"})
+	assert.ErrorContains(t, err, "not part of contract inventory")
+	_, err = stub.SubmitContractTransaction("CreateSampleObject", nil, "k")
+	assert.ErrorContains(t, err, "takes 2 arguments")
+	assert.Equal(t, blocks, len(stub.GetBlocks()))
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package contract
+
+import (
+	"testing"
+
+	"github.com/Akachain/akc-go-sdk-v2/mock"
+	"github.com/Akachain/akc-go-sdk-v2/util"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"gotest.tools/assert"
+)
+
+const totalsTable = "TOTALS"
+
+type Total struct {
+	Value int64 `json:"Value"`
+}
+
+// counterInvoke keeps the same total twice: in a single row updated in place
+// and in a delta-key counter.
+func counterInvoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, _ := stub.GetFunctionAndParameters()
+	var err error
+	switch function {
+	case "AddToRow":
+		var total Total
+		_, err = util.GetTableRow(stub, totalsTable, []string{"hot"}, &total, util.DONT_FAIL_IF_MISSING)
+		if err == nil {
+			total.Value++
+			_, err = util.InsertTableRow(stub, totalsTable, []string{"hot"}, &total, util.DONT_FAIL_UPON_OVERWRITE, nil)
+		}
+	case "AddToCounter":
+		err = util.AddToCounter(stub, "hot", 1)
+	case "Compact":
+		_, err = util.CompactCounter(stub, "hot")
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func countValid(results []mock.ConcurrentTxResult) int {
+	valid := 0
+	for _, result := range results {
+		if result.ValidationCode == pb.TxValidationCode_VALID {
+			valid++
+		}
+	}
+	return valid
+}
+
+func TestDeltaCounterAvoidsConflicts(t *testing.T) {
+	stub := setupChaincodeMock("counter", counterInvoke)
+	const concurrent = 5
+
+	rowTxs := make([][][]byte, concurrent)
+	counterTxs := make([][][]byte, concurrent)
+	for i := 0; i < concurrent; i++ {
+		rowTxs[i] = [][]byte{[]byte("AddToRow")}
+		counterTxs[i] = [][]byte{[]byte("AddToCounter")}
+	}
+
+	// Every transaction of the block reads the hot row, only the first one survives
+	assert.Equal(t, 1, countValid(stub.MockConcurrentInvoke(rowTxs)))
+
+	// Deltas never touch the same key
+	assert.Equal(t, concurrent, countValid(stub.MockConcurrentInvoke(counterTxs)))
+	stub.MockTransactionStart("read")
+	value, err := util.GetCounterValue(stub, "hot")
+	stub.MockTransactionEnd("read")
+	assert.NilError(t, err)
+	assert.Equal(t, int64(concurrent), value)
+
+	// Compaction folds the deltas into the base key without changing the value
+	mock.MockInvokeTransaction(t, stub, [][]byte{[]byte("Compact")})
+	assert.Equal(t, concurrent, countValid(stub.MockConcurrentInvoke(counterTxs)))
+	stub.MockTransactionStart("read2")
+	value, err = util.GetCounterValue(stub, "hot")
+	stub.MockTransactionEnd("read2")
+	assert.NilError(t, err)
+	assert.Equal(t, int64(2*concurrent), value)
+}
+
+func TestDeltaKeysOfSimulations(t *testing.T) {
+	stub := setupChaincodeMock("counter", counterInvoke)
+
+	// Every simulation of the transaction writes the same delta key
+	stub.SetDryRun(true)
+	options := mock.InvokeOptions{TxID: "tx-add"}
+	first := mock.InvokeTransaction(t, stub, [][]byte{[]byte("AddToCounter")}, options)
+	second := mock.InvokeTransaction(t, stub, [][]byte{[]byte("AddToCounter")}, options)
+	assert.Equal(t, 1, len(first.Writes))
+	assert.DeepEqual(t, first.Writes, second.Writes)
+}
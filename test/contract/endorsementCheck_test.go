@@ -0,0 +1,118 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package contract
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Akachain/akc-go-sdk-v2/mock"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"gotest.tools/assert"
+)
+
+// stampInvoke writes values that are, or are not, the same on every endorser.
+func stampInvoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, _ := stub.GetFunctionAndParameters()
+	var value string
+	switch function {
+	case "TxTime":
+		timestamp, _ := stub.GetTxTimestamp()
+		value = stub.GetTxID() + " " + timestamp.String()
+	case "Now":
+		value = strconv.FormatInt(time.Now().UnixNano(), 10)
+	case "Keys":
+		keys := make(map[int]bool)
+		for i := 0; i < 100; i++ {
+			keys[i] = true
+		}
+		for key := range keys {
+			value += strconv.Itoa(key) + ","
+		}
+	}
+	if err := stub.PutState(function, []byte(value)); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(value))
+}
+
+func TestEndorsementCheck(t *testing.T) {
+	stub := setupChaincodeMock("stamp", stampInvoke)
+	stub.SetEndorsementCheck(mock.EndorsementCheck{Endorsers: 3, Interval: time.Millisecond})
+
+	// Every endorser gets the same transaction context
+	result := mock.InvokeTransaction(t, stub, [][]byte{[]byte("TxTime")})
+	assert.Assert(t, result.Committed)
+	assert.Equal(t, 0, len(result.Divergence))
+	state, _ := stub.GetState("TxTime")
+	assert.Equal(t, string(result.Payload), string(state))
+
+	// The executions take a single tick of the clock
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	stub.SetClock(mock.NewMockClock(start, time.Second))
+	assert.Assert(t, mock.InvokeTransaction(t, stub, [][]byte{[]byte("TxTime")}).Timestamp.Equal(start))
+	assert.Assert(t, mock.InvokeTransaction(t, stub, [][]byte{[]byte("TxTime")}).Timestamp.Equal(start.Add(time.Second)))
+
+	result = stub.ExecuteTransaction([][]byte{[]byte("Now")}, mock.InvokeOptions{})
+	assert.Assert(t, !result.OK())
+	assert.Assert(t, !result.Committed)
+	assert.Assert(t, strings.Contains(result.Message, "ProposalResponsePayloads do not match"), result.Message)
+	assert.Equal(t, "endorser 1 and endorser 2 differ:", result.Divergence[0])
+	assert.Assert(t, strings.HasPrefix(result.Divergence[1], `- response 200`), result.Divergence[1])
+	state, _ = stub.GetState("Now")
+	assert.Assert(t, state == nil)
+	blocks := stub.GetBlocks()
+	assert.Assert(t, blocks[len(blocks)-1].Transactions[0].TxID != result.TxID)
+
+	// Rejected transactions are left out of the block
+	stub.SetEndorsementCheck(mock.EndorsementCheck{Endorsers: 5})
+	results := stub.MockConcurrentInvoke([][][]byte{{[]byte("Keys")}, {[]byte("TxTime")}})
+	assert.Equal(t, pb.TxValidationCode_ENDORSEMENT_POLICY_FAILURE, results[0].ValidationCode)
+	assert.Equal(t, pb.TxValidationCode_VALID, results[1].ValidationCode)
+	block := stub.GetBlocks()[len(blocks)]
+	assert.Equal(t, 1, len(block.Transactions))
+	assert.Equal(t, results[1].TxID, block.Transactions[0].TxID)
+
+	// Without the check, the transaction is committed
+	stub.SetEndorsementCheck(mock.EndorsementCheck{})
+	mock.InvokeTransaction(t, stub, [][]byte{[]byte("Keys")})
+	state, _ = stub.GetState("Keys")
+	assert.Assert(t, state != nil)
+}
+
+func TestEndorsementCheckOfUtilHelpers(t *testing.T) {
+	stub := setupChaincodeMock("ids", idInvoke)
+	stub.SetEndorsementCheck(mock.EndorsementCheck{Endorsers: 3})
+
+	// Generated IDs and sequence values are the same on every endorser and when committed
+	first := mock.InvokeTransaction(t, stub, [][]byte{[]byte("Next")})
+	assert.Equal(t, 0, len(first.Divergence))
+	assert.Equal(t, first.TxID+"-0 1", string(first.Payload))
+	second := mock.InvokeTransaction(t, stub, [][]byte{[]byte("Next")})
+	assert.Equal(t, second.TxID+"-0 2", string(second.Payload))
+
+	counterStub := setupChaincodeMock("counter", counterInvoke)
+	counterStub.SetEndorsementCheck(mock.EndorsementCheck{Endorsers: 3})
+	results := counterStub.MockConcurrentInvoke([][][]byte{{[]byte("AddToCounter")}, {[]byte("AddToCounter")}})
+	assert.Equal(t, 2, countValid(results))
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package contract
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Akachain/akc-go-sdk-v2/mock"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// eventInvoke sets one event per argument, named after the argument.
+func eventInvoke(stub shim.ChaincodeStubInterface) pb.Response {
+	for _, name := range stub.GetStringArgs() {
+		if err := stub.SetEvent(name, []byte(`{"name":"`+name+`","count":1}`)); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	return shim.Success(nil)
+}
+
+func TestEventCapture(t *testing.T) {
+	stub := setupChaincodeMock("events", eventInvoke)
+
+	_, events := mock.MockInvokeTransaction(t, stub, [][]byte{[]byte("Created"), []byte("Approved")})
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	event := mock.AssertEventName(t, events, "Approved")
+	mock.AssertEventPayload(t, event, map[string]interface{}{"count": 1, "name": "Approved"})
+	if stub.GetEvent(event.TxId) != event {
+		t.Errorf("GetEvent does not return the last event of the transaction")
+	}
+
+	_, events = mock.MockInvokeTransaction(t, stub, [][]byte{})
+	mock.AssertNoEvent(t, events)
+
+	// Every replaced event is reported, even by an event of the same name
+	result := mock.InvokeTransaction(t, stub, [][]byte{[]byte("Created"), []byte("Created")})
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "replaces event Created") {
+		t.Errorf("expected a warning about the replaced event, got %q", result.Warnings)
+	}
+}
+
+func TestBlockEvents(t *testing.T) {
+	stub := setupChaincodeMock("counter", counterInvoke)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var numbers []uint64
+	unregister := stub.OnBlock(func(block *mock.BlockEvent) { numbers = append(numbers, block.Number) })
+	mock.MockInvokeTransaction(t, stub, [][]byte{[]byte("AddToRow")})
+	stub.MockConcurrentInvoke([][][]byte{{[]byte("AddToRow")}, {[]byte("AddToRow")}})
+	unregister()
+	mock.MockInvokeTransaction(t, stub, [][]byte{[]byte("AddToRow")})
+	if len(numbers) != 2 || numbers[0] != 1 || numbers[1] != 2 {
+		t.Errorf("OnBlock received blocks %v, expected [1 2]", numbers)
+	}
+
+	// Listeners can start from an earlier block
+	blocks := stub.BlockEvents(ctx, 2)
+	block := <-blocks
+	if block.Number != 2 || len(block.Transactions) != 2 {
+		t.Fatalf("expected block 2 with 2 transactions, got block %d with %d", block.Number, len(block.Transactions))
+	}
+	first, second := block.Transactions[0], block.Transactions[1]
+	if first.ValidationCode != pb.TxValidationCode_VALID || second.ValidationCode != pb.TxValidationCode_MVCC_READ_CONFLICT {
+		t.Errorf("unexpected validation codes %v and %v", first.ValidationCode, second.ValidationCode)
+	}
+	if len(first.Reads) != 1 || first.Reads[0].Version == nil || len(first.Writes) != 1 {
+		t.Errorf("unexpected read/write set %+v", first)
+	}
+	if block = <-blocks; block.Number != 3 {
+		t.Errorf("expected block 3, got block %d", block.Number)
+	}
+
+	// Chaincode events of valid transactions are streamed as they are committed
+	eventStub := setupChaincodeMock("events", eventInvoke)
+	chaincodeEvents := eventStub.ChaincodeEvents(ctx, 0)
+	mock.MockInvokeTransaction(t, eventStub, [][]byte{[]byte("Shipped")})
+	event := <-chaincodeEvents
+	if event.BlockNumber != 1 || event.EventName != "Shipped" || event.ChaincodeName != "events" {
+		t.Errorf("unexpected chaincode event %+v", event)
+	}
+
+	cancel()
+	if _, open := <-chaincodeEvents; open {
+		t.Errorf("the channel of chaincode events is still open after the context is done")
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package contract
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Akachain/akc-go-sdk-v2/common"
+	"github.com/Akachain/akc-go-sdk-v2/mock"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"gotest.tools/assert"
+)
+
+// ledgerInvoke stores a public and a private value, or fails with a common error.
+func ledgerInvoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	switch function {
+	case "Store":
+		if _, err := stub.GetState(args[0]); err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := stub.PutState(args[0], []byte(args[1])); err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := stub.PutPrivateData("secrets", args[0], []byte(args[2])); err != nil {
+			return shim.Error(err.Error())
+		}
+		stub.SetEvent("Stored", []byte(args[0]))
+		return common.RespondSuccess(common.ResponseSuccess{ResCode: common.SUCCESS, Payload: `{"key":"` + args[0] + `"}`})
+	case "StoreThenFail":
+		if err := stub.PutState(args[0], []byte(args[1])); err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Error("failed after the write")
+	case "Read":
+		value, err := stub.GetState(args[0])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(value)
+	default:
+		return common.RespondError(common.ResponseError{ResCode: common.ERR5, Msg: common.ResCodeDict[common.ERR5], Details: args})
+	}
+}
+
+func TestInvokeResult(t *testing.T) {
+	stub := setupChaincodeMock("ledger", ledgerInvoke)
+
+	result := mock.InvokeTransaction(t, stub, [][]byte{[]byte("Store"), []byte("k"), []byte("public"), []byte("private")})
+	assert.Assert(t, result.OK())
+	assert.Assert(t, result.TxID != "")
+	assert.Assert(t, !result.Timestamp.IsZero())
+	assert.Equal(t, 1, len(result.Reads))
+	assert.Equal(t, "k", result.Reads[0].Key)
+	assert.Equal(t, 1, len(result.Writes))
+	assert.Equal(t, "public", string(result.Writes[0].Value))
+	assert.Equal(t, 1, len(result.PrivateWrites))
+	assert.Equal(t, "secrets", result.PrivateWrites[0].Collection)
+	assert.Equal(t, "Stored", result.Event().EventName)
+	assert.Assert(t, result.Envelope == nil)
+	var payload map[string]string
+	assert.NilError(t, result.DecodePayload(&payload))
+	assert.Equal(t, "k", payload["key"])
+
+	result = mock.InvokeTransactionExpectError(t, stub, [][]byte{[]byte("Fail"), []byte("why")}, common.ERR5)
+	assert.Equal(t, int32(common.ERROR), result.Status)
+	assert.Equal(t, common.ResCodeDict[common.ERR5], result.Envelope.Msg)
+	assert.Equal(t, `["why"]`, string(result.Envelope.Details))
+	assert.Equal(t, 0, len(result.Writes))
+
+	// Failures are described with the whole context of the transaction
+	description := result.String()
+	assert.Assert(t, strings.Contains(description, result.TxID))
+	assert.Assert(t, strings.Contains(description, common.ERR5))
+	assert.Assert(t, strings.Contains(description, `"Fail"`))
+}
+
+func TestRespondError(t *testing.T) {
+	var message map[string]interface{}
+	res := common.RespondError(common.ResponseError{ResCode: common.ERR3, Msg: `bad "quoted" value`})
+	assert.NilError(t, json.Unmarshal([]byte(res.Message), &message))
+	assert.DeepEqual(t, map[string]interface{}{"status": common.ERR3, "msg": `bad "quoted" value`}, message)
+
+	// Details that cannot be encoded are replaced with the encoding error
+	res = common.RespondError(common.ResponseError{ResCode: common.ERR3, Msg: "bad value", Details: make(chan int)})
+	assert.NilError(t, json.Unmarshal([]byte(res.Message), &message))
+	assert.Assert(t, strings.HasPrefix(message["details"].(string), "encoding the details failed"), message["details"])
+}
+
+func TestReadOnlyQueries(t *testing.T) {
+	stub := setupChaincodeMock("ledger", ledgerInvoke)
+	mock.InvokeTransaction(t, stub, [][]byte{[]byte("Store"), []byte("k"), []byte("v1"), []byte("p1")})
+	store := [][]byte{[]byte("Store"), []byte("k"), []byte("v2"), []byte("p2")}
+	blocks := len(stub.GetBlocks())
+
+	result := mock.QueryTransaction(t, stub, [][]byte{[]byte("Read"), []byte("k")})
+	assert.Equal(t, "v1", string(result.Payload))
+	assert.Assert(t, !result.Committed)
+	assert.Equal(t, 1, len(result.Reads))
+
+	// Writes are rejected by default
+	result = stub.ExecuteQuery(store, mock.InvokeOptions{})
+	assert.Assert(t, !result.OK())
+	assert.Assert(t, strings.Contains(result.Message, "not allowed in a query transaction"))
+	assert.DeepEqual(t, []string{`PutState("k")`}, result.Violations)
+
+	// or reported, and discarded
+	stub.SetQueryWriteMode(mock.QUERY_REPORT_WRITES)
+	result = stub.ExecuteQuery(store, mock.InvokeOptions{})
+	assert.Assert(t, result.OK())
+	assert.DeepEqual(t, []string{`PutState("k")`, `PutPrivateData(secrets, "k")`, "SetEvent(Stored)"}, result.Violations)
+	value, _ := stub.GetState("k")
+	assert.Equal(t, "v1", string(value))
+	private, _ := stub.GetPrivateData("secrets", "k")
+	assert.Equal(t, "p1", string(private))
+
+	// A dry run reports the writes of an invoke without committing them
+	stub.SetDryRun(true)
+	result = mock.InvokeTransaction(t, stub, store)
+	assert.Assert(t, !result.Committed)
+	assert.Equal(t, 0, len(result.Violations))
+	assert.Equal(t, "v2", string(result.Writes[0].Value))
+	assert.Equal(t, "p2", string(result.PrivateWrites[0].Value))
+	value, _ = stub.GetState("k")
+	assert.Equal(t, "v1", string(value))
+	private, _ = stub.GetPrivateData("secrets", "k")
+	assert.Equal(t, "p1", string(private))
+	assert.Equal(t, blocks, len(stub.GetBlocks()))
+
+	// as well as concurrent invokes, which are not validated
+	results := stub.MockConcurrentInvoke([][][]byte{store, {[]byte("StoreThenFail"), []byte("k"), []byte("v3")}})
+	assert.Equal(t, pb.TxValidationCode_NOT_VALIDATED, results[0].ValidationCode)
+	assert.Equal(t, pb.TxValidationCode_ENDORSEMENT_POLICY_FAILURE, results[1].ValidationCode)
+	value, _ = stub.GetState("k")
+	assert.Equal(t, "v1", string(value))
+	assert.Equal(t, blocks, len(stub.GetBlocks()))
+
+	stub.SetDryRun(false)
+	assert.Assert(t, mock.InvokeTransaction(t, stub, store).Committed)
+	value, _ = stub.GetState("k")
+	assert.Equal(t, "v2", string(value))
+}
+
+func TestFailedTransactions(t *testing.T) {
+	stub := setupChaincodeMock("ledger", ledgerInvoke)
+	blocks := 0
+	stub.OnBlock(func(block *mock.BlockEvent) { blocks++ })
+
+	mock.InvokeTransaction(t, stub, [][]byte{[]byte("Store"), []byte("k"), []byte("public"), []byte("private")})
+	assert.Equal(t, 1, blocks)
+
+	// The writes of a failed transaction are discarded and it gets no block
+	res := stub.MockInvoke("tx-fail", [][]byte{[]byte("StoreThenFail"), []byte("k"), []byte("changed")})
+	assert.Equal(t, int32(shim.ERROR), res.Status)
+	assert.Equal(t, 1, blocks)
+	value, _ := stub.GetState("k")
+	assert.Equal(t, "public", string(value))
+
+	// Failed transactions are left out of a block of concurrent transactions
+	results := stub.MockConcurrentInvoke([][][]byte{
+		{[]byte("Store"), []byte("k1"), []byte("public"), []byte("private")},
+		{[]byte("StoreThenFail"), []byte("k2"), []byte("changed")},
+		{[]byte("Store"), []byte("k3"), []byte("public"), []byte("private")},
+	})
+	assert.Equal(t, pb.TxValidationCode_ENDORSEMENT_POLICY_FAILURE, results[1].ValidationCode)
+	assert.Equal(t, 2, blocks)
+	block := stub.GetBlocks()[1]
+	assert.Equal(t, uint64(2), block.Number)
+	assert.Equal(t, 2, len(block.Transactions))
+	assert.Equal(t, results[0].TxID, block.Transactions[0].TxID)
+	assert.Equal(t, "k1", block.Transactions[0].Writes[0].Key)
+	assert.Equal(t, results[2].TxID, block.Transactions[1].TxID)
+	assert.Equal(t, "k3", block.Transactions[1].Writes[0].Key)
+	value, _ = stub.GetState("k2")
+	assert.Assert(t, value == nil)
+
+	// and no block is committed if they all fail
+	stub.MockConcurrentInvoke([][][]byte{{[]byte("StoreThenFail"), []byte("k2"), []byte("changed")}})
+	assert.Equal(t, 2, blocks)
+	assert.Equal(t, 1, len(mock.InvokeTransaction(t, stub, [][]byte{[]byte("Read"), []byte("k")}).Reads))
+	assert.Equal(t, uint64(3), stub.GetBlocks()[2].Number)
+}
+
+func TestFailedInvokeResult(t *testing.T) {
+	stub := setupChaincodeMock("ledger", ledgerInvoke)
+	mock.InvokeTransaction(t, stub, [][]byte{[]byte("Store"), []byte("k"), []byte("public"), []byte("private")})
+
+	result := stub.ExecuteTransaction([][]byte{[]byte("StoreThenFail"), []byte("k"), []byte("changed")}, mock.InvokeOptions{})
+	assert.Assert(t, !result.OK())
+	assert.Assert(t, !result.Committed)
+	assert.Equal(t, 1, len(result.Writes))
+	value, _ := stub.GetState("k")
+	assert.Equal(t, "public", string(value))
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package contract
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Akachain/akc-go-sdk-v2/util"
+	"gotest.tools/assert"
+)
+
+func TestKeyEncodingOrder(t *testing.T) {
+	ints := []int64{-1 << 63, -100, -9, -1, 0, 9, 10, 100, 1<<63 - 1}
+	decimals := []string{"-1000", "-12.5", "-12.05", "-12", "-0.5", "-0.05", "0", "0.001", "0.5", "9", "10", "10.01", "123.456"}
+
+	var encodedInts, encodedDecimals, descending []string
+	for _, v := range ints {
+		component := util.EncodeIntKey(v)
+		decoded, err := util.DecodeIntKey(component)
+		assert.NilError(t, err)
+		assert.Equal(t, v, decoded)
+		encodedInts = append(encodedInts, component)
+
+		reversed, err := util.EncodeDescendingKey(component)
+		assert.NilError(t, err)
+		descending = append([]string{reversed}, descending...)
+	}
+	for _, v := range decimals {
+		component, err := util.EncodeDecimalKey(v)
+		assert.NilError(t, err)
+		decoded, err := util.DecodeDecimalKey(component)
+		assert.NilError(t, err)
+		assert.Equal(t, v, decoded)
+		encodedDecimals = append(encodedDecimals, component)
+	}
+
+	assert.Assert(t, sort.StringsAreSorted(encodedInts))
+	assert.Assert(t, sort.StringsAreSorted(encodedDecimals))
+	assert.Assert(t, sort.StringsAreSorted(descending))
+}
+
+func TestGetTableRowsByRange(t *testing.T) {
+	stub := setupMemoryMock()
+	stub.MockTransactionStart("tx-range")
+	defer stub.MockTransactionEnd("tx-range")
+
+	start := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	for day := 0; day < 10; day++ {
+		placed := util.EncodeTimeKey(start.AddDate(0, 0, day))
+		assert.NilError(t, util.CreateData(stub, "ORDERS", []string{"shop", placed}, &Member{ID: placed}))
+	}
+
+	from, _ := util.EncodeRFC3339Key("2021-03-03T07:00:00+07:00")
+	to := util.EncodeTimeKey(start.AddDate(0, 0, 5))
+	rows, err := util.GetTableRowsByRange(stub, "ORDERS", []string{"shop"}, from, to)
+	assert.NilError(t, err)
+	assert.Equal(t, 3, len(drain(rows)))
+}
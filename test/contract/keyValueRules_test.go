@@ -0,0 +1,57 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package contract
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Akachain/akc-go-sdk-v2/mock"
+	"gotest.tools/assert"
+)
+
+func TestKeyValueRules(t *testing.T) {
+	stub := setupMemoryMock()
+	stub.MockTransactionStart("tx1")
+	defer stub.MockTransactionEnd("tx1")
+
+	// core.yaml selects CouchDB, whose checks apply even to the memory state
+	assert.Equal(t, mock.COUCH_DB, stub.KeyValueRules().Database)
+	assert.ErrorContains(t, stub.PutState("", []byte("v")), "empty string")
+	assert.ErrorContains(t, stub.PutState("\xff\xfe", []byte("v")), "must be a UTF-8 string")
+	assert.ErrorContains(t, stub.PutState("_design", []byte("v")), `cannot begin with "_"`)
+	assert.ErrorContains(t, stub.PutState("doc", []byte(`{"_id":"doc"}`)), "_id")
+	assert.ErrorContains(t, stub.PutState("doc", []byte(`{"~version":"1"}`)), "~version")
+	assert.ErrorContains(t, stub.PutPrivateData("secrets", "_secret", []byte("v")), `cannot begin with "_"`)
+	assert.NilError(t, stub.PutState("doc", []byte(`{"id":"doc","nested":{"_id":"allowed"}}`)))
+	assert.NilError(t, stub.PutState("binary", []byte{0x00, 0xff}))
+
+	value, _ := stub.GetState("_design")
+	assert.Assert(t, value == nil)
+
+	// LevelDB only refuses empty keys, and the size limit is configurable
+	stub.SetKeyValueRules(mock.KeyValueRules{Database: mock.LEVEL_DB, MaxValueSize: 16})
+	assert.NilError(t, stub.PutState("_design", []byte(`{"_id":"doc"}`)))
+	assert.ErrorContains(t, stub.PutState("big", []byte(strings.Repeat("x", 17))), "exceeds the limit of 16 bytes")
+	assert.ErrorContains(t, stub.DelState(""), "empty string")
+
+	stub.SetKeyValueRules(mock.KeyValueRules{Disabled: true})
+	assert.NilError(t, stub.PutState("big", []byte(strings.Repeat("x", 17))))
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package contract
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Akachain/akc-go-sdk-v2/mock"
+	"github.com/Akachain/akc-go-sdk-v2/util"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"gotest.tools/assert"
+)
+
+// rosterInvoke adds members to a table and stores how many there are.
+func rosterInvoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	switch function {
+	case "Insert":
+		if err := util.CreateData(stub, "ROSTER", []string{args[0]}, &Member{ID: args[0]}); err != nil {
+			return shim.Error(err.Error())
+		}
+	case "Count":
+		rows, err := util.GetTableRows(stub, "ROSTER", []string{})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := stub.PutState("count", []byte(strconv.Itoa(len(drain(rows))))); err != nil {
+			return shim.Error(err.Error())
+		}
+	case "Search":
+		// The write comes first, so the error of the rich query is the one returned
+		if err := stub.PutState("searched", []byte("true")); err != nil {
+			return shim.Error(err.Error())
+		}
+		if _, err := stub.GetQueryResult(`{"selector":{"ID":"a"}}`); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	return shim.Success(nil)
+}
+
+func TestPhantomReads(t *testing.T) {
+	stub := setupChaincodeMock("roster", rosterInvoke)
+	mock.InvokeTransaction(t, stub, [][]byte{[]byte("Insert"), []byte("a")})
+
+	// The count misses the member inserted earlier in the block
+	results := stub.MockConcurrentInvoke([][][]byte{
+		{[]byte("Insert"), []byte("b")},
+		{[]byte("Count")},
+	})
+	assert.Equal(t, pb.TxValidationCode_VALID, results[0].ValidationCode)
+	assert.Equal(t, pb.TxValidationCode_PHANTOM_READ_CONFLICT, results[1].ValidationCode)
+	state, _ := stub.GetState("count")
+	assert.Assert(t, state == nil)
+
+	// A member inserted after the count does not invalidate it
+	results = stub.MockConcurrentInvoke([][][]byte{
+		{[]byte("Count")},
+		{[]byte("Insert"), []byte("c")},
+	})
+	assert.Equal(t, pb.TxValidationCode_VALID, results[0].ValidationCode)
+	assert.Equal(t, pb.TxValidationCode_VALID, results[1].ValidationCode)
+	state, _ = stub.GetState("count")
+	assert.Equal(t, "2", string(state))
+}
+
+func TestRichQueryInUpdate(t *testing.T) {
+	stub := setupChaincodeMock("roster", rosterInvoke)
+
+	// The mock has no CouchDB, so the rich query fails once the policy is applied
+	result := stub.ExecuteTransaction([][]byte{[]byte("Search")}, mock.InvokeOptions{})
+	assert.Assert(t, strings.Contains(result.Message, "requires CouchDB"), result.Message)
+	assert.Equal(t, 1, len(result.Warnings))
+	assert.Assert(t, strings.Contains(result.Warnings[0], "not validated at commit"), result.Warnings[0])
+
+	stub.SetRichQueryPolicy(mock.RICH_QUERY_REJECT)
+	result = stub.ExecuteTransaction([][]byte{[]byte("Search")}, mock.InvokeOptions{})
+	assert.Assert(t, strings.Contains(result.Message, "not validated at commit"), result.Message)
+	assert.Equal(t, 0, len(result.Warnings))
+
+	stub.SetRichQueryPolicy(mock.RICH_QUERY_IGNORE)
+	result = stub.ExecuteTransaction([][]byte{[]byte("Search")}, mock.InvokeOptions{})
+	assert.Equal(t, 0, len(result.Warnings))
+}
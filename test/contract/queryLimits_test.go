@@ -0,0 +1,85 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package contract
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Akachain/akc-go-sdk-v2/mock"
+	"github.com/Akachain/akc-go-sdk-v2/util"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"gotest.tools/assert"
+)
+
+// countInvoke counts the rows of the table named by its argument.
+func countInvoke(stub shim.ChaincodeStubInterface) pb.Response {
+	rows, err := util.GetTableRows(stub, stub.GetStringArgs()[0], []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(strconv.Itoa(len(drain(rows)))))
+}
+
+func TestQueryLimits(t *testing.T) {
+	stub := setupChaincodeMock("count", countInvoke)
+
+	// The limits come from core.yaml
+	limits := stub.QueryLimits()
+	assert.Equal(t, 100000, limits.TotalQueryLimit)
+	assert.Equal(t, 1000, limits.InternalQueryLimit)
+
+	stub.MockTransactionStart("setup")
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		assert.NilError(t, util.CreateData(stub, "LIMITED", []string{id}, &Member{ID: id}))
+	}
+	assert.NilError(t, stub.PutState("k1", []byte("1")))
+	assert.NilError(t, stub.PutState("k2", []byte("2")))
+	stub.MockTransactionEnd("setup")
+
+	stub.SetQueryLimits(mock.QueryLimits{TotalQueryLimit: 3})
+	result := mock.QueryTransaction(t, stub, [][]byte{[]byte("LIMITED")})
+	assert.Equal(t, "3", string(result.Payload))
+	assert.Equal(t, 0, len(result.Warnings))
+
+	stub.SetQueryLimits(mock.QueryLimits{TotalQueryLimit: 3, WarnOnTruncation: true})
+	result = mock.QueryTransaction(t, stub, [][]byte{[]byte("LIMITED")})
+	assert.Equal(t, "3", string(result.Payload))
+	assert.Equal(t, 1, len(result.Warnings))
+	assert.Assert(t, strings.Contains(result.Warnings[0], "truncated to totalQueryLimit 3"))
+
+	// Queries that fit in the limit are not reported
+	stub.SetQueryLimits(mock.QueryLimits{TotalQueryLimit: 5, WarnOnTruncation: true})
+	result = mock.QueryTransaction(t, stub, [][]byte{[]byte("LIMITED")})
+	assert.Equal(t, "5", string(result.Payload))
+	assert.Equal(t, 0, len(result.Warnings))
+
+	stub.SetQueryLimits(mock.QueryLimits{TotalQueryLimit: 1})
+	stub.MockTransactionStart("range")
+	defer stub.MockTransactionEnd("range")
+	iterator, err := stub.GetStateByRange("k1", "k9")
+	assert.NilError(t, err)
+	assert.Assert(t, iterator.HasNext())
+	_, err = iterator.Next()
+	assert.NilError(t, err)
+	assert.Assert(t, !iterator.HasNext())
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package contract
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Akachain/akc-go-sdk-v2/util"
+	"gotest.tools/assert"
+)
+
+func TestTableRowHistory(t *testing.T) {
+	stub := setupMemoryMock()
+	keys := []string{"h1"}
+	writes := []func() error{
+		func() error { return util.CreateData(stub, "HISTORY", keys, &Member{ID: "h1", Email: "a@example.com"}) },
+		func() error {
+			// Only the last write of a transaction is kept
+			if err := util.UpdateExistingData(stub, "HISTORY", keys, &Member{ID: "h1", Email: "b@example.com"}); err != nil {
+				return err
+			}
+			return util.UpdateExistingData(stub, "HISTORY", keys, &Member{ID: "h1", Email: "c@example.com"})
+		},
+		func() error {
+			_, err := util.DeleteTableRow(stub, "HISTORY", keys, nil, util.FAIL_IF_MISSING)
+			return err
+		},
+		func() error { return util.CreateData(stub, "HISTORY", keys, &Member{ID: "h1", Email: "d@example.com"}) },
+	}
+	for i, write := range writes {
+		txID := string(rune('1' + i))
+		stub.MockTransactionStart(txID)
+		assert.NilError(t, write())
+		stub.MockTransactionEnd(txID)
+	}
+
+	stub.MockTransactionStart("query")
+	defer stub.MockTransactionEnd("query")
+	page, bookmark, err := util.GetTableRowHistory(stub, "HISTORY", keys, 3, "")
+	assert.NilError(t, err)
+	assert.Equal(t, 3, len(page))
+	assert.Equal(t, "2", bookmark)
+	assert.Equal(t, "4", page[0].TxID)
+	assert.Assert(t, page[1].IsDelete)
+	var member Member
+	found, err := page[2].DecodeValue(&member)
+	assert.NilError(t, err)
+	assert.Assert(t, found)
+	assert.Equal(t, "c@example.com", member.Email)
+	assert.Assert(t, !page[2].Timestamp.IsZero())
+
+	page, bookmark, err = util.GetTableRowHistory(stub, "HISTORY", keys, 3, bookmark)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(page))
+	assert.Equal(t, "1", page[0].TxID)
+	assert.Equal(t, "", bookmark)
+}
+
+func TestTableRowAsOf(t *testing.T) {
+	stub := setupMemoryMock()
+	times := make([]time.Time, 0)
+	write := func(txID string, write func() error) {
+		stub.MockTransactionStart(txID)
+		defer stub.MockTransactionEnd(txID)
+		assert.NilError(t, write())
+		txTime, err := util.GetTxTime(stub)
+		assert.NilError(t, err)
+		times = append(times, txTime)
+	}
+	write("t1", func() error { return util.CreateData(stub, "ASOF", []string{"a"}, &Member{ID: "a", Email: "a1"}) })
+	write("t2", func() error { return util.CreateData(stub, "ASOF", []string{"b"}, &Member{ID: "b", Email: "b1"}) })
+	write("t3", func() error {
+		_, err := util.DeleteTableRow(stub, "ASOF", []string{"a"}, nil, util.FAIL_IF_MISSING)
+		return err
+	})
+	write("t4", func() error { return util.CreateData(stub, "ASOF", []string{"a"}, &Member{ID: "a", Email: "a2"}) })
+
+	stub.MockTransactionStart("query")
+	defer stub.MockTransactionEnd("query")
+
+	var member Member
+	found, err := util.GetTableRowAsOf(stub, "ASOF", []string{"a"}, times[1], &member)
+	assert.NilError(t, err)
+	assert.Assert(t, found)
+	assert.Equal(t, "a1", member.Email)
+	found, err = util.GetTableRowAsOf(stub, "ASOF", []string{"a"}, times[2], nil)
+	assert.NilError(t, err)
+	assert.Assert(t, !found)
+	found, err = util.GetTableRowAsOf(stub, "ASOF", []string{"a"}, times[0].Add(-time.Second), nil)
+	assert.NilError(t, err)
+	assert.Assert(t, !found)
+
+	found, err = util.GetTableRowAsOfTx(stub, "ASOF", []string{"a"}, "t4", &member)
+	assert.NilError(t, err)
+	assert.Assert(t, found)
+	assert.Equal(t, "a2", member.Email)
+	_, err = util.GetTableRowAsOfTx(stub, "ASOF", []string{"a"}, "t2", nil)
+	assert.Assert(t, err != nil)
+
+	rows, err := util.GetTableRowsAsOf(stub, "ASOF", []string{}, times[1])
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(rows))
+	assert.DeepEqual(t, []string{"a"}, rows[0].RowKeys)
+	assert.Equal(t, "t1", rows[0].TxID)
+
+	rows, err = util.GetTableRowsAsOfTx(stub, "ASOF", []string{}, "t3")
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(rows))
+	assert.DeepEqual(t, []string{"b"}, rows[0].RowKeys)
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package contract
+
+import (
+	"testing"
+
+	"github.com/Akachain/akc-go-sdk-v2/util"
+	"gotest.tools/assert"
+)
+
+type Order struct {
+	ID     string            `json:"ID"`
+	Status string            `json:"Status"`
+	Items  []string          `json:"Items"`
+	Tags   map[string]string `json:"Tags,omitempty"`
+}
+
+const orderTable = "ORDER"
+
+func TestPatchTableRow(t *testing.T) {
+	registerTableSchema(t, orderTable, util.TableSchema{KeyFields: []string{"ID"}})
+	stub := setupMemoryMock()
+	stub.MockTransactionStart("tx1")
+	defer stub.MockTransactionEnd("tx1")
+
+	order := Order{ID: "o1", Status: "PENDING", Items: []string{"a", "b"}, Tags: map[string]string{"rush": "yes"}}
+	assert.NilError(t, util.CreateData(stub, orderTable, []string{"o1"}, &order))
+
+	// A merge patch changes the status and removes a member
+	var patched Order
+	_, err := util.MergePatchTableRow(stub, orderTable, []string{"o1"}, []byte(`{"Status":"PAID","Tags":{"rush":null}}`), util.REJECT_KEY_FIELD_CHANGES, &patched)
+	assert.NilError(t, err)
+	assert.Equal(t, "PAID", patched.Status)
+	assert.Equal(t, 0, len(patched.Tags))
+
+	// Key fields are protected when asked to
+	_, err = util.MergePatchTableRow(stub, orderTable, []string{"o1"}, []byte(`{"ID":"o2"}`), util.REJECT_KEY_FIELD_CHANGES, nil)
+	assert.ErrorContains(t, err, "key field ID")
+
+	// A JSON Patch is applied as a whole or not at all
+	_, err = util.JSONPatchTableRow(stub, orderTable, []string{"o1"}, []byte(`[
+		{"op":"add","path":"/Items/-","value":"c"},
+		{"op":"test","path":"/Status","value":"PENDING"}
+	]`), util.REJECT_KEY_FIELD_CHANGES, nil)
+	assert.ErrorContains(t, err, "operation 1")
+
+	row, err := util.JSONPatchTableRow(stub, orderTable, []string{"o1"}, []byte(`[
+		{"op":"test","path":"/Status","value":"PAID"},
+		{"op":"move","from":"/Items/0","path":"/Items/-"},
+		{"op":"replace","path":"/Status","value":"SHIPPED"}
+	]`), util.REJECT_KEY_FIELD_CHANGES, &patched)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"b", "a"}, patched.Items)
+
+	var stored Order
+	_, err = util.GetTableRow(stub, orderTable, []string{"o1"}, &stored, util.FAIL_IF_MISSING)
+	assert.NilError(t, err)
+	assert.Equal(t, "SHIPPED", stored.Status)
+	assert.Assert(t, len(row) > 0)
+}
@@ -23,6 +23,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/Akachain/akc-go-sdk-v2/mock"
+	"github.com/Akachain/akc-go-sdk-v2/util"
 	"github.com/hyperledger/fabric-chaincode-go/shimtest"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"gotest.tools/assert"
@@ -73,3 +74,30 @@ func TestSimpleData(t *testing.T) {
 	assert.Equal(t, key1, ad[0].Key1)
 	assert.Equal(t, val1, ad[0].Attribute1)
 }
+
+func TestSimulatedTransactions(t *testing.T) {
+	stub := setupMemoryMock()
+	compositeKey, _ := stub.CreateCompositeKey(DocPrefix, []string{"key1"})
+
+	// An invoke is simulated like on a peer, then committed as a block of its own
+	mock.MockInvokeTransaction(t, stub, [][]byte{[]byte("CreateSampleObject"), []byte("key1"), []byte("val1")})
+	assert.Equal(t, 1, len(stub.GetBlocks()))
+
+	// The writes of a failed invoke are discarded and it gets no block
+	result := stub.ExecuteTransaction([][]byte{[]byte("CreateSampleObject"), []byte("key1"), []byte("val2")}, mock.InvokeOptions{})
+	assert.Assert(t, !result.Committed)
+	assert.Equal(t, 1, len(stub.GetBlocks()))
+	state, _ := stub.GetState(compositeKey)
+	var data SampleData
+	assert.NilError(t, json.Unmarshal(state, &data))
+	assert.Equal(t, "val1", data.Attribute1)
+
+	// An invoke reads the committed state only, it does not see its own writes. Writes made
+	// between MockTransactionStart and MockTransactionEnd are applied at once instead, which
+	// is handy to set up a test.
+	stub.MockTransactionStart("setup")
+	assert.NilError(t, util.CreateData(stub, DocPrefix, []string{"key2"}, &SampleData{Key1: "key2", Attribute1: "val1"}))
+	assert.Assert(t, util.CreateData(stub, DocPrefix, []string{"key2"}, &SampleData{Key1: "key2", Attribute1: "val2"}) != nil)
+	stub.MockTransactionEnd("setup")
+	assert.Equal(t, 1, len(stub.GetBlocks()))
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package contract
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Akachain/akc-go-sdk-v2/common"
+	"github.com/Akachain/akc-go-sdk-v2/util"
+	"gotest.tools/assert"
+)
+
+type Delivery struct {
+	ID         string `json:"ID"`
+	Status     string `json:"Status"`
+	TrackingNo string `json:"TrackingNo,omitempty"`
+}
+
+var deliveryLifecycle = util.StateMachine{
+	Table:      "DELIVERY",
+	StateField: "Status",
+	States:     []string{"CREATED", "APPROVED", "SENT", "DELIVERED"},
+	Transitions: []util.Transition{
+		{Name: "approve", From: []string{"CREATED"}, To: "APPROVED", Guards: []util.TransitionGuard{util.RequireMSP("Org1MSP")}},
+		{Name: "send", From: []string{"CREATED", "APPROVED"}, To: "SENT", Effects: []util.TransitionEffect{
+			func(ctx *util.TransitionContext) error {
+				ctx.Row["TrackingNo"] = ctx.Input
+				return nil
+			},
+		}},
+		{Name: "deliver", From: []string{"SENT"}, To: "DELIVERED"},
+	},
+}
+
+func TestStateMachine(t *testing.T) {
+	assert.NilError(t, deliveryLifecycle.Validate())
+	stub := setupMemoryMock()
+	keys := []string{"d1"}
+
+	stub.MockTransactionStart("tx1")
+	assert.NilError(t, util.CreateData(stub, "DELIVERY", keys, &Delivery{ID: "d1", Status: "CREATED"}))
+	stub.MockTransactionEnd("tx1")
+
+	// Illegal transitions and failing guards are rejected with distinct codes
+	stub.MockTransactionStart("tx2")
+	_, err := deliveryLifecycle.Apply(stub, keys, "deliver", nil, nil)
+	assert.Assert(t, util.IsTransitionError(err))
+	assert.Equal(t, common.ERR20, err.(*util.TransitionError).Code)
+	_, err = deliveryLifecycle.Apply(stub, keys, "approve", nil, nil)
+	assert.Equal(t, common.ERR21, err.(*util.TransitionError).Code)
+	stub.MockTransactionEnd("tx2")
+
+	stub.MockTransactionStart("tx3")
+	var delivery Delivery
+	record, err := deliveryLifecycle.Apply(stub, keys, "send", "TRK-42", &delivery)
+	assert.NilError(t, err)
+	assert.Equal(t, "CREATED", record.From)
+	assert.Equal(t, "SENT", delivery.Status)
+	assert.Equal(t, "TRK-42", delivery.TrackingNo)
+	stub.MockTransactionEnd("tx3")
+
+	event := <-stub.ChaincodeEventsChannel
+	assert.Equal(t, util.DefaultTransitionEvent, event.EventName)
+	var emitted util.TransitionRecord
+	assert.NilError(t, json.Unmarshal(event.Payload, &emitted))
+	assert.Equal(t, "send", emitted.Transition)
+
+	stub.MockTransactionStart("tx4")
+	_, err = deliveryLifecycle.Apply(stub, keys, "deliver", nil, nil)
+	assert.NilError(t, err)
+	history, err := util.GetTransitionHistory(stub, "DELIVERY", keys)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, "DELIVERED", history[1].To)
+	stub.MockTransactionEnd("tx4")
+}
@@ -0,0 +1,351 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package contract
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Akachain/akc-go-sdk-v2/mock"
+	"github.com/Akachain/akc-go-sdk-v2/util"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"gotest.tools/assert"
+)
+
+type Member struct {
+	ID    string `json:"ID"`
+	Email string `json:"Email"`
+}
+
+const memberTable = "MEMBER"
+
+// setupMemoryMock creates a MockStubExtend that keeps the state in memory
+// so that util can be tested without a CouchDB instance.
+func setupMemoryMock() *mock.MockStubExtend {
+	sc := new(SampleContract)
+	chaincode, _ := contractapi.NewChaincode(sc)
+	return mock.NewMockStubExtend(shimtest.NewMockStub("samplecontract", chaincode), chaincode, ".")
+}
+
+// invokeChaincode is a chaincode whose Init succeeds and whose Invoke is a test function.
+type invokeChaincode struct {
+	invoke func(stub shim.ChaincodeStubInterface) pb.Response
+}
+
+func (cc *invokeChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (cc *invokeChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return cc.invoke(stub)
+}
+
+// setupChaincodeMock returns a memory mock of the chaincode name whose Invoke is invoke.
+func setupChaincodeMock(name string, invoke func(stub shim.ChaincodeStubInterface) pb.Response) *mock.MockStubExtend {
+	cc := &invokeChaincode{invoke: invoke}
+	return mock.NewMockStubExtend(shimtest.NewMockStub(name, cc), cc, ".")
+}
+
+// registerTableSchema registers the schema of a table until the end of the test, when the
+// schema registered before, if any, is restored.
+func registerTableSchema(t *testing.T, tableName string, schema util.TableSchema) {
+	previous, found := util.GetTableSchema(tableName)
+	util.RegisterTableSchema(tableName, schema)
+	t.Cleanup(func() {
+		if found {
+			util.RegisterTableSchema(tableName, previous)
+		} else {
+			util.UnregisterTableSchema(tableName)
+		}
+	})
+}
+
+// uniqueInvoke creates members given as ID and email pairs in one transaction, after
+// changing the email of m1 if asked to.
+func uniqueInvoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	if function == "Rename" {
+		if err := util.ChangeInfo(stub, memberTable, []string{"m1"}, &Member{ID: "m1", Email: args[0]}); err != nil {
+			return shim.Error(err.Error())
+		}
+		args = args[1:]
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		if err := util.CreateData(stub, memberTable, []string{args[i]}, &Member{ID: args[i], Email: args[i+1]}); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	return shim.Success(nil)
+}
+
+func TestUniqueFields(t *testing.T) {
+	registerTableSchema(t, memberTable, util.TableSchema{UniqueFields: []string{"Email"}})
+	stub := setupMemoryMock()
+
+	stub.MockTransactionStart("tx1")
+	assert.NilError(t, util.CreateData(stub, memberTable, []string{"m1"}, &Member{ID: "m1", Email: "a@akc.com"}))
+	stub.MockTransactionEnd("tx1")
+
+	// A second row cannot take the same email
+	stub.MockTransactionStart("tx2")
+	err := util.CreateData(stub, memberTable, []string{"m2"}, &Member{ID: "m2", Email: "a@akc.com"})
+	assert.Assert(t, util.IsUniqueViolation(err))
+	found, _ := util.GetTableRow(stub, memberTable, []string{"m2"}, nil, util.DONT_FAIL_IF_MISSING)
+	assert.Assert(t, !found)
+	stub.MockTransactionEnd("tx2")
+
+	// Changing the email releases the old value
+	stub.MockTransactionStart("tx3")
+	assert.NilError(t, util.ChangeInfo(stub, memberTable, []string{"m1"}, &Member{ID: "m1", Email: "b@akc.com"}))
+	stub.MockTransactionEnd("tx3")
+	stub.MockTransactionStart("tx4")
+	assert.NilError(t, util.CreateData(stub, memberTable, []string{"m2"}, &Member{ID: "m2", Email: "a@akc.com"}))
+	stub.MockTransactionEnd("tx4")
+
+	stub.MockTransactionStart("tx5")
+	var m Member
+	found, err = util.GetTableRowByUniqueField(stub, memberTable, "Email", "b@akc.com", &m)
+	assert.NilError(t, err)
+	assert.Assert(t, found)
+	assert.Equal(t, "m1", m.ID)
+	stub.MockTransactionEnd("tx5")
+
+	// Deleting a row releases its values
+	stub.MockTransactionStart("tx6")
+	_, err = util.DeleteTableRow(stub, memberTable, []string{"m2"}, nil, util.FAIL_IF_MISSING)
+	assert.NilError(t, err)
+	stub.MockTransactionEnd("tx6")
+	stub.MockTransactionStart("tx7")
+	assert.NilError(t, util.CreateData(stub, memberTable, []string{"m3"}, &Member{ID: "m3", Email: "a@akc.com"}))
+	stub.MockTransactionEnd("tx7")
+}
+
+func TestUniqueFieldsInOneTransaction(t *testing.T) {
+	registerTableSchema(t, memberTable, util.TableSchema{UniqueFields: []string{"Email"}})
+	stub := setupChaincodeMock("unique", uniqueInvoke)
+	mock.InvokeTransaction(t, stub, [][]byte{[]byte("Create"), []byte("m1"), []byte("a@akc.com")})
+
+	// Like on a peer, the transactions do not see their own writes
+	stub.SetDryRun(true)
+	result := stub.ExecuteTransaction([][]byte{[]byte("Create"), []byte("m2"), []byte("c@akc.com"), []byte("m3"), []byte("c@akc.com")}, mock.InvokeOptions{})
+	assert.Assert(t, strings.Contains(result.Message, "unique violation"), result.Message)
+
+	// A value released by the transaction can be taken again
+	mock.InvokeTransaction(t, stub, [][]byte{[]byte("Rename"), []byte("b@akc.com"), []byte("m2"), []byte("a@akc.com")})
+	result = stub.ExecuteTransaction([][]byte{[]byte("Rename"), []byte("b@akc.com"), []byte("m2"), []byte("b@akc.com")}, mock.InvokeOptions{})
+	assert.Assert(t, strings.Contains(result.Message, "unique violation"), result.Message)
+}
+
+type Account struct {
+	ID      string `json:"ID"`
+	Balance int    `json:"Balance"`
+	Version uint64 `json:"Version"`
+}
+
+const accountTable = "ACCOUNT"
+
+func TestVersionField(t *testing.T) {
+	registerTableSchema(t, accountTable, util.TableSchema{VersionField: "Version"})
+	stub := setupMemoryMock()
+	stub.MockTransactionStart("tx1")
+	defer stub.MockTransactionEnd("tx1")
+
+	assert.NilError(t, util.CreateData(stub, accountTable, []string{"a1"}, &Account{ID: "a1", Balance: 10}))
+
+	var read Account
+	_, err := util.GetTableRow(stub, accountTable, []string{"a1"}, &read, util.FAIL_IF_MISSING)
+	assert.NilError(t, err)
+	assert.Equal(t, uint64(1), read.Version)
+
+	// The first writer wins and bumps the version
+	read.Balance = 20
+	assert.NilError(t, util.UpdateExistingData(stub, accountTable, []string{"a1"}, &read))
+	version, err := util.GetTableRowVersion(stub, accountTable, []string{"a1"})
+	assert.NilError(t, err)
+	assert.Equal(t, uint64(2), version)
+
+	// A second writer holding the same stale read is rejected
+	read.Balance = 30
+	err = util.ChangeInfo(stub, accountTable, []string{"a1"}, &read)
+	assert.Assert(t, util.IsVersionConflict(err))
+}
+
+const contractTable = "CONTRACT"
+
+func TestSoftDelete(t *testing.T) {
+	registerTableSchema(t, contractTable, util.TableSchema{UniqueFields: []string{"Email"}, SoftDelete: true})
+	stub := setupMemoryMock()
+	stub.MockTransactionStart("tx1")
+	defer stub.MockTransactionEnd("tx1")
+
+	assert.NilError(t, util.CreateData(stub, contractTable, []string{"c1"}, &Member{ID: "c1", Email: "c@akc.com"}))
+	found, err := util.DeleteTableRow(stub, contractTable, []string{"c1"}, nil, util.FAIL_IF_MISSING)
+	assert.NilError(t, err)
+	assert.Assert(t, found)
+
+	// The row is hidden by default but still readable on demand
+	found, _ = util.GetTableRow(stub, contractTable, []string{"c1"}, nil, util.DONT_FAIL_IF_MISSING)
+	assert.Assert(t, !found)
+	var m Member
+	found, _ = util.GetTableRowIncludeDeleted(stub, contractTable, []string{"c1"}, &m, util.FAIL_IF_MISSING)
+	assert.Assert(t, found)
+	assert.Equal(t, "c@akc.com", m.Email)
+	tombstone, err := util.GetTableRowTombstone(stub, contractTable, []string{"c1"})
+	assert.NilError(t, err)
+	assert.Equal(t, "tx1", tombstone.TxID)
+
+	rows, _ := util.GetTableRows(stub, contractTable, []string{})
+	assert.Equal(t, 0, len(drain(rows)))
+	rows, _ = util.GetTableRowsIncludeDeleted(stub, contractTable, []string{})
+	assert.Equal(t, 1, len(drain(rows)))
+
+	// The keys stay taken until the row is restored or purged
+	assert.Assert(t, util.CreateData(stub, contractTable, []string{"c1"}, &Member{ID: "c1"}) != nil)
+	assert.NilError(t, util.RestoreTableRow(stub, contractTable, []string{"c1"}))
+	found, _ = util.GetTableRowByUniqueField(stub, contractTable, "Email", "c@akc.com", nil)
+	assert.Assert(t, found)
+
+	found, err = util.PurgeTableRow(stub, contractTable, []string{"c1"}, nil, util.FAIL_IF_MISSING)
+	assert.NilError(t, err)
+	assert.Assert(t, found)
+	found, _ = util.GetTableRowIncludeDeleted(stub, contractTable, []string{"c1"}, nil, util.DONT_FAIL_IF_MISSING)
+	assert.Assert(t, !found)
+}
+
+func drain(rows chan []byte) [][]byte {
+	all := make([][]byte, 0)
+	for row := range rows {
+		all = append(all, row)
+	}
+	return all
+}
+
+const auditedTable = "AUDITED"
+
+func TestAuditTrail(t *testing.T) {
+	registerTableSchema(t, auditedTable, util.TableSchema{Audit: util.AUDIT_DIFF})
+	stub := setupMemoryMock()
+
+	stub.MockTransactionStart("tx1")
+	assert.NilError(t, util.CreateData(stub, auditedTable, []string{"a"}, &Member{ID: "a", Email: "old@akc.com"}))
+	stub.MockTransactionEnd("tx1")
+	stub.MockTransactionStart("tx2")
+	assert.NilError(t, util.ChangeInfo(stub, auditedTable, []string{"a"}, &Member{ID: "a", Email: "new@akc.com"}))
+	_, err := util.DeleteTableRow(stub, auditedTable, []string{"b"}, nil, util.DONT_FAIL_IF_MISSING)
+	assert.NilError(t, err)
+	stub.MockTransactionEnd("tx2")
+
+	entries, err := util.GetAuditTrailByEntity(stub, auditedTable, []string{"a"})
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, util.AUDIT_INSERT, entries[0].Operation)
+	assert.Equal(t, util.AUDIT_UPDATE, entries[1].Operation)
+	assert.Equal(t, "tx2", entries[1].TxID)
+	assert.Equal(t, entries[0].NewHash, entries[1].OldHash)
+	assert.Equal(t, 1, len(entries[1].Changes))
+	assert.Equal(t, "Email", entries[1].Changes[0].Field)
+
+	entries, err = util.GetAuditTrailByActor(stub, "", "")
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(entries))
+
+	from := entries[0].Timestamp
+	entries, err = util.GetAuditTrailByTimeRange(stub, from.Add(-time.Hour), from.Add(time.Hour))
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(entries))
+	entries, err = util.GetAuditTrailByTimeRange(stub, from.Add(time.Hour), from.Add(2*time.Hour))
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(entries))
+}
+
+func TestAuditTrailOfUpdates(t *testing.T) {
+	registerTableSchema(t, "AUDITED_UPDATES", util.TableSchema{Audit: util.AUDIT_DIFF})
+	stub := setupMemoryMock()
+
+	stub.MockTransactionStart("tx1")
+	assert.NilError(t, util.CreateData(stub, "AUDITED_UPDATES", []string{"a"}, &Member{ID: "a", Email: "old@akc.com"}))
+	stub.MockTransactionEnd("tx1")
+	stub.MockTransactionStart("tx2")
+	assert.NilError(t, util.UpdateExistingData(stub, "AUDITED_UPDATES", []string{"a"}, &Member{ID: "a", Email: "new@akc.com"}))
+	stub.MockTransactionEnd("tx2")
+
+	entries, err := util.GetAuditTrailByEntity(stub, "AUDITED_UPDATES", []string{"a"})
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, util.AUDIT_UPDATE, entries[1].Operation)
+	assert.Equal(t, entries[0].NewHash, entries[1].OldHash)
+	assert.Equal(t, 1, len(entries[1].Changes))
+	assert.Equal(t, "Email", entries[1].Changes[0].Field)
+}
+
+func TestCreateDataWithID(t *testing.T) {
+	stub := setupMemoryMock()
+	stub.MockTransactionStart("tx-ids")
+	defer stub.MockTransactionEnd("tx-ids")
+
+	id, err := util.CreateDataWithID(stub, "ORDER", []string{"shop"}, &Member{ID: "o"}, util.TX_BASED_ID)
+	assert.NilError(t, err)
+	assert.Equal(t, "tx-ids-0", id)
+	assert.Equal(t, "tx-ids-1", util.NewTxBasedID(stub))
+
+	// Sequence values keep counting inside one transaction
+	first, err := util.CreateDataWithID(stub, "ORDER", []string{"shop"}, &Member{ID: "o1"}, util.SEQUENCE_ID)
+	assert.NilError(t, err)
+	second, err := util.CreateDataWithID(stub, "ORDER", []string{"shop"}, &Member{ID: "o2"}, util.SEQUENCE_ID)
+	assert.NilError(t, err)
+	assert.Equal(t, "00000000000000000001", first)
+	assert.Equal(t, "00000000000000000002", second)
+
+	found, _ := util.GetTableRow(stub, "ORDER", []string{"shop", second}, nil, util.FAIL_IF_MISSING)
+	assert.Assert(t, found)
+
+	// Identical content gets the same ID, so it can only be created once
+	_, err = util.CreateDataWithID(stub, "ORDER", []string{"shop"}, &Member{ID: "same"}, util.CONTENT_ID)
+	assert.NilError(t, err)
+	_, err = util.CreateDataWithID(stub, "ORDER", []string{"shop"}, &Member{ID: "same"}, util.CONTENT_ID)
+	assert.Assert(t, err != nil)
+}
+
+// idInvoke returns a tx based ID and the next value of a sequence.
+func idInvoke(stub shim.ChaincodeStubInterface) pb.Response {
+	sequence, err := util.NextSequenceValue(stub, "ORDER")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(util.NewTxBasedID(stub) + " " + strconv.FormatUint(sequence, 10)))
+}
+
+func TestIDsOfSimulations(t *testing.T) {
+	stub := setupChaincodeMock("ids", idInvoke)
+
+	// Every simulation of a transaction generates the same IDs
+	stub.SetDryRun(true)
+	options := mock.InvokeOptions{TxID: "tx-again"}
+	first := mock.InvokeTransaction(t, stub, [][]byte{[]byte("Next")}, options)
+	second := mock.InvokeTransaction(t, stub, [][]byte{[]byte("Next")}, options)
+	assert.Equal(t, "tx-again-0 1", string(first.Payload))
+	assert.Equal(t, string(first.Payload), string(second.Payload))
+	assert.Equal(t, 1, len(second.Reads))
+}
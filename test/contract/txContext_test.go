@@ -0,0 +1,134 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package contract
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Akachain/akc-go-sdk-v2/mock"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/common"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"gotest.tools/assert"
+)
+
+type txContext struct {
+	TxID       string
+	Timestamp  time.Time
+	ChannelID  string
+	Transient  string
+	Decoration string
+	Binding    []byte
+	Proposal   []byte
+}
+
+// contextInvoke returns the context of the transaction it runs in.
+func contextInvoke(stub shim.ChaincodeStubInterface) pb.Response {
+	ts, _ := stub.GetTxTimestamp()
+	timestamp, _ := ptypes.Timestamp(ts)
+	transient, _ := stub.GetTransient()
+	binding, _ := stub.GetBinding()
+	proposal, _ := stub.GetSignedProposal()
+	payload, _ := json.Marshal(&txContext{
+		TxID:       stub.GetTxID(),
+		Timestamp:  timestamp,
+		ChannelID:  stub.GetChannelID(),
+		Transient:  string(transient["secret"]),
+		Decoration: string(stub.GetDecorations()["peer"]),
+		Binding:    binding,
+		Proposal:   proposal.GetProposalBytes(),
+	})
+	return shim.Success(payload)
+}
+
+func invokeContext(t *testing.T, stub *mock.MockStubExtend, options mock.InvokeOptions) txContext {
+	_, res := stub.MockInvokeWithOptions([][]byte{[]byte("context")}, options)
+	assert.Equal(t, int32(shim.OK), res.Status)
+	var ctx txContext
+	assert.NilError(t, json.Unmarshal(res.Payload, &ctx))
+	return ctx
+}
+
+func TestMockTxContext(t *testing.T) {
+	newStub := func() *mock.MockStubExtend {
+		stub := setupChaincodeMock("context", contextInvoke)
+		stub.SeedTxIDs(42)
+		return stub
+	}
+	start := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	stub := newStub()
+	stub.SetClock(mock.NewMockClock(start, time.Second))
+
+	// The clock gives the timestamps and moves by its step
+	first := invokeContext(t, stub, mock.InvokeOptions{})
+	second := invokeContext(t, stub, mock.InvokeOptions{})
+	assert.Assert(t, first.Timestamp.Equal(start))
+	assert.Assert(t, second.Timestamp.Equal(start.Add(time.Second)))
+	stub.Clock().Advance(24 * time.Hour)
+	third := invokeContext(t, stub, mock.InvokeOptions{})
+	assert.Assert(t, third.Timestamp.Equal(start.Add(24*time.Hour+2*time.Second)))
+
+	// Seeded stubs generate the same transaction IDs
+	other := newStub()
+	assert.Equal(t, first.TxID, invokeContext(t, other, mock.InvokeOptions{}).TxID)
+	assert.Assert(t, first.TxID != second.TxID)
+
+	// Options only apply to their transaction
+	fixed := start.Add(-time.Hour)
+	ctx := invokeContext(t, stub, mock.InvokeOptions{
+		TxID:        "tx-options",
+		Timestamp:   fixed,
+		ChannelID:   "audit",
+		Transient:   map[string][]byte{"secret": []byte("s3cr3t")},
+		Decorations: map[string][]byte{"peer": []byte("peer0")},
+	})
+	assert.Equal(t, "tx-options", ctx.TxID)
+	assert.Assert(t, ctx.Timestamp.Equal(fixed))
+	assert.Equal(t, "audit", ctx.ChannelID)
+	assert.Equal(t, "s3cr3t", ctx.Transient)
+	assert.Equal(t, "peer0", ctx.Decoration)
+	after := invokeContext(t, stub, mock.InvokeOptions{})
+	assert.Equal(t, "", after.ChannelID)
+	assert.Equal(t, "", after.Transient)
+
+	// Options without transient data keep the data set on the stub
+	stub.TransientMap = map[string][]byte{"secret": []byte("kept")}
+	assert.Equal(t, "kept", invokeContext(t, stub, mock.InvokeOptions{}).Transient)
+
+	// The proposal carries the context and the binding is derived from it
+	var proposal pb.Proposal
+	var header common.Header
+	var channelHeader common.ChannelHeader
+	var payload pb.ChaincodeProposalPayload
+	assert.NilError(t, proto.Unmarshal(ctx.Proposal, &proposal))
+	assert.NilError(t, proto.Unmarshal(proposal.Header, &header))
+	assert.NilError(t, proto.Unmarshal(header.ChannelHeader, &channelHeader))
+	assert.NilError(t, proto.Unmarshal(proposal.Payload, &payload))
+	assert.Equal(t, "tx-options", channelHeader.TxId)
+	assert.Equal(t, "audit", channelHeader.ChannelId)
+	assert.Equal(t, fixed.Unix(), channelHeader.Timestamp.Seconds)
+	assert.Equal(t, "s3cr3t", string(payload.TransientMap["secret"]))
+	assert.Equal(t, 32, len(ctx.Binding))
+	assert.Assert(t, string(ctx.Binding) != string(after.Binding))
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package contract
+
+import (
+	"testing"
+
+	"github.com/Akachain/akc-go-sdk-v2/util"
+	"gotest.tools/assert"
+)
+
+type Shipment struct {
+	ID       string          `json:"ID" akc:"required,regex=^SH-[0-9]{4,}$"`
+	Status   string          `json:"Status" akc:"enum=CREATED|SENT|DELIVERED"`
+	Weight   float64         `json:"Weight" akc:"min=0.1,max=500"`
+	Parcels  []Parcel        `json:"Parcels" akc:"minlen=1"`
+	Labels   []string        `json:"Labels,omitempty" akc:"maxlen=3,dive,required,maxlen=8"`
+	Receiver *ShipmentParty  `json:"Receiver" akc:"required"`
+	Extras   map[string]bool `json:"Extras,omitempty"`
+}
+
+type Parcel struct {
+	Code string `json:"Code" akc:"len=6"`
+}
+
+type ShipmentParty struct {
+	Name    string `json:"Name" akc:"required"`
+	Country string `json:"Country" akc:"required,len=2"`
+}
+
+const shipmentTable = "SHIPMENT"
+
+func TestValidationTags(t *testing.T) {
+	stub := setupMemoryMock()
+	stub.MockTransactionStart("tx1")
+	defer stub.MockTransactionEnd("tx1")
+
+	invalid := Shipment{
+		ID:       "SH-12",
+		Status:   "LOST",
+		Weight:   0,
+		Parcels:  []Parcel{{Code: "ABCDEF"}, {Code: "ABC"}},
+		Labels:   []string{"fragile", "", "this-is-too-long"},
+		Receiver: &ShipmentParty{Country: "VNM"},
+	}
+	err := util.CreateData(stub, shipmentTable, []string{"SH-12"}, &invalid)
+	assert.Assert(t, util.IsValidationError(err))
+
+	var fields []string
+	for _, violation := range util.ValidateStruct(&invalid).(*util.ValidationError).Violations {
+		fields = append(fields, violation.Field+":"+violation.Rule)
+	}
+	assert.DeepEqual(t, []string{
+		"ID:regex",
+		"Status:enum",
+		"Weight:min",
+		"Parcels[1].Code:len",
+		"Labels[1]:required",
+		"Labels[2]:maxlen",
+		"Receiver.Name:required",
+		"Receiver.Country:len",
+	}, fields)
+
+	// The argument decoder applies the same tags
+	err = util.DecodeArgument(`{"ID":"SH-0001","Status":"SENT","Weight":2,"Parcels":[{"Code":"ABCDEF"}]}`, &Shipment{})
+	assert.ErrorContains(t, err, "Receiver is required")
+
+	valid := Shipment{ID: "SH-0001", Status: "CREATED", Weight: 2, Parcels: []Parcel{{Code: "ABCDEF"}}, Receiver: &ShipmentParty{Name: "An", Country: "VN"}}
+	assert.NilError(t, util.CreateData(stub, shipmentTable, []string{"SH-0001"}, &valid))
+
+	// Patched rows are validated too when they are decoded into a model
+	_, err = util.MergePatchTableRow(stub, shipmentTable, []string{"SH-0001"}, []byte(`{"Status":"LOST"}`), util.ALLOW_KEY_FIELD_CHANGES, &Shipment{})
+	assert.ErrorContains(t, err, "Status must be one of CREATED, SENT, DELIVERED")
+}
+
+// Contact is tagged for go-playground/validator, whose rules util does not know.
+type Contact struct {
+	ID    string `json:"ID" validate:"required"`
+	Email string `json:"Email" validate:"required,email"`
+	Age   int    `json:"Age" validate:"gte=0,lte=130"`
+}
+
+func TestValidationTagsOfOtherLibraries(t *testing.T) {
+	stub := setupMemoryMock()
+	stub.MockTransactionStart("tx1")
+	defer stub.MockTransactionEnd("tx1")
+
+	assert.NilError(t, util.ValidateStruct(&Contact{}))
+	assert.NilError(t, util.CreateData(stub, "CONTACT", []string{"c1"}, &Contact{ID: "c1", Email: "c1@akc.com"}))
+}
+
+func TestValidationOfMaps(t *testing.T) {
+	parcels := map[string]Parcel{"c": {Code: "C"}, "a": {Code: "A"}, "b": {Code: "B"}}
+	for i := 0; i < 10; i++ {
+		var fields []string
+		for _, violation := range util.ValidateStruct(parcels).(*util.ValidationError).Violations {
+			fields = append(fields, violation.Field)
+		}
+		assert.DeepEqual(t, []string{"[a].Code", "[b].Code", "[c].Code"}, fields)
+	}
+}
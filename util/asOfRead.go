@@ -0,0 +1,224 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package util
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// The functions below rebuild rows as they were at a point in time by walking the history of
+// their keys. A row "as of" a time is the last modification whose transaction timestamp is not
+// after that time; a row "as of" a transaction is the row right after that transaction committed.
+// Transaction timestamps are set by the clients, so they only follow the commit order as long as
+// the clocks of the clients do. Like GetTableRowHistory, they need the history database and must
+// not be used in transactions that write.
+
+// RowSnapshot is the value of a row as of a point in time together with the modification
+// that produced it.
+type RowSnapshot struct {
+	RowKeys []string
+	RowHistoryEntry
+}
+
+// GetTableRowAsOf reads a row as it was at as_of into row_value. Rows that did not exist yet,
+// or were deleted or soft deleted at that time, are not found.
+func GetTableRowAsOf(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	row_keys []string,
+	as_of time.Time,
+	row_value interface{},
+) (rowWasFound bool, err error) {
+	snapshot, found, err := getTableRowSnapshot(stub, table_name, row_keys, as_of, "")
+	if err != nil || !found {
+		return false, err
+	}
+	if row_value == nil {
+		return true, nil
+	}
+	return snapshot.DecodeValue(row_value)
+}
+
+// GetTableRowAsOfTx reads a row as it was right after transaction tx_id. The transaction must
+// have modified the row, since the history of a key does not know about other transactions.
+func GetTableRowAsOfTx(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	row_keys []string,
+	tx_id string,
+	row_value interface{},
+) (rowWasFound bool, err error) {
+	snapshot, found, err := getTableRowSnapshot(stub, table_name, row_keys, time.Time{}, tx_id)
+	if err != nil || !found {
+		return false, err
+	}
+	if row_value == nil {
+		return true, nil
+	}
+	return snapshot.DecodeValue(row_value)
+}
+
+// GetTableRowsAsOf returns the rows of table_name under the partial key row_keys as they were
+// at as_of, in key order.
+//
+// The keys are enumerated from the current state, so rows that have been hard deleted since
+// as_of cannot be found; tables that must be fully reconstructed should use SoftDelete.
+func GetTableRowsAsOf(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	row_keys []string,
+	as_of time.Time,
+) ([]RowSnapshot, error) {
+	histories, err := readTableHistories(stub, table_name, row_keys)
+	if err != nil {
+		return nil, err
+	}
+	return snapshotsAsOf(histories, as_of, ""), nil
+}
+
+// GetTableRowsAsOfTx returns the rows of table_name under the partial key row_keys as they were
+// right after transaction tx_id, in key order. At least one of the rows must have been modified
+// by the transaction, which gives the timestamp used for the others. The limitation of
+// GetTableRowsAsOf on hard deleted rows applies.
+func GetTableRowsAsOfTx(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	row_keys []string,
+	tx_id string,
+) ([]RowSnapshot, error) {
+	histories, err := readTableHistories(stub, table_name, row_keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, history := range histories {
+		for _, entry := range history.entries {
+			if entry.TxID == tx_id {
+				return snapshotsAsOf(histories, entry.Timestamp, tx_id), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("GetTableRowsAsOfTx failed because transaction %s did not modify any row of %s under %v", tx_id, table_name, row_keys)
+}
+
+// getTableRowSnapshot reads the history of one row and selects the snapshot as of as_of, or
+// as of tx_id when it is set.
+func getTableRowSnapshot(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	row_keys []string,
+	as_of time.Time,
+	tx_id string,
+) (RowSnapshot, bool, error) {
+	composite_key, err := stub.CreateCompositeKey(table_name, row_keys)
+	if err != nil {
+		return RowSnapshot{}, false, fmt.Errorf("getTableRowSnapshot failed because stub.CreateCompositeKey failed with error %v", err)
+	}
+	entries, err := readRowHistory(stub, table_name, composite_key)
+	if err != nil {
+		return RowSnapshot{}, false, err
+	}
+	if tx_id != "" {
+		found := false
+		for _, entry := range entries {
+			if entry.TxID == tx_id {
+				as_of, found = entry.Timestamp, true
+				break
+			}
+		}
+		if !found {
+			return RowSnapshot{}, false, fmt.Errorf("getTableRowSnapshot failed because transaction %s did not modify row %v of %s", tx_id, row_keys, table_name)
+		}
+	}
+	entry, found := selectAsOf(entries, as_of, tx_id)
+	if !found {
+		return RowSnapshot{}, false, nil
+	}
+	return RowSnapshot{RowKeys: row_keys, RowHistoryEntry: entry}, true, nil
+}
+
+type rowHistory struct {
+	row_keys []string
+	entries  []RowHistoryEntry
+}
+
+// readTableHistories reads the history of every row stored under a partial key, soft deleted
+// rows included.
+func readTableHistories(stub shim.ChaincodeStubInterface, table_name string, row_keys []string) ([]rowHistory, error) {
+	state_query_iterator, err := stub.GetStateByPartialCompositeKey(table_name, row_keys)
+	if err != nil {
+		return nil, fmt.Errorf("readTableHistories failed because stub.GetStateByPartialCompositeKey failed with error %v", err)
+	}
+	defer state_query_iterator.Close()
+
+	histories := make([]rowHistory, 0)
+	for state_query_iterator.HasNext() {
+		query_result_kv, err := state_query_iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("readTableHistories failed because iterator.Next failed with error %v", err)
+		}
+		_, keys, err := stub.SplitCompositeKey(query_result_kv.Key)
+		if err != nil {
+			return nil, fmt.Errorf("readTableHistories failed because stub.SplitCompositeKey failed with error %v", err)
+		}
+		entries, err := readRowHistory(stub, table_name, query_result_kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		histories = append(histories, rowHistory{row_keys: keys, entries: entries})
+	}
+	return histories, nil
+}
+
+// snapshotsAsOf selects the rows that existed as of a point in time.
+func snapshotsAsOf(histories []rowHistory, as_of time.Time, tx_id string) []RowSnapshot {
+	snapshots := make([]RowSnapshot, 0, len(histories))
+	for _, history := range histories {
+		if entry, found := selectAsOf(history.entries, as_of, tx_id); found {
+			snapshots = append(snapshots, RowSnapshot{RowKeys: history.row_keys, RowHistoryEntry: entry})
+		}
+	}
+	return snapshots
+}
+
+// selectAsOf picks, in a history ordered from the most recent modification, the modification
+// of tx_id if there is one and otherwise the first one that is not after as_of. It reports
+// false when the row did not exist at that point.
+func selectAsOf(entries []RowHistoryEntry, as_of time.Time, tx_id string) (RowHistoryEntry, bool) {
+	selected := -1
+	for i, entry := range entries {
+		if tx_id != "" && entry.TxID == tx_id {
+			selected = i
+			break
+		}
+		if selected < 0 && !entry.Timestamp.After(as_of) {
+			selected = i
+			if tx_id == "" {
+				break
+			}
+		}
+	}
+	if selected < 0 || entries[selected].IsDelete || entries[selected].IsSoftDeleted {
+		return RowHistoryEntry{}, false
+	}
+	return entries[selected], true
+}
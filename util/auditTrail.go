@@ -0,0 +1,290 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package util
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// This is effectively a strongly typed enum declaration.
+type AuditMode uint8
+
+const (
+	AUDIT_OFF  AuditMode = 0 // no audit entry is written
+	AUDIT_HASH AuditMode = 1 // entries carry the SHA-256 of the old and new rows
+	AUDIT_DIFF AuditMode = 2 // entries also carry the top level fields that changed
+)
+
+// Audit entries are stored under AuditPrefix and indexed by actor and by day under
+// AuditActorPrefix and AuditTimePrefix. The index keys hold the key of the entry.
+const (
+	AuditPrefix      = "AKC~AUDIT"
+	AuditActorPrefix = "AKC~AUDIT~ACTOR"
+	AuditTimePrefix  = "AKC~AUDIT~TIME"
+)
+
+// Operations recorded in AuditEntry.Operation
+const (
+	AUDIT_INSERT  = "INSERT"
+	AUDIT_UPDATE  = "UPDATE"
+	AUDIT_DELETE  = "DELETE"
+	AUDIT_RESTORE = "RESTORE"
+	AUDIT_PURGE   = "PURGE"
+)
+
+// AuditEntry describes one write performed through util on an audited table.
+// A row written several times in one transaction has a single entry, matching the
+// single write that the ledger keeps.
+type AuditEntry struct {
+	Table     string             `json:"Table"`
+	RowKeys   []string           `json:"RowKeys"`
+	Operation string             `json:"Operation"`
+	OldHash   string             `json:"OldHash,omitempty"`
+	NewHash   string             `json:"NewHash,omitempty"`
+	Changes   []AuditFieldChange `json:"Changes,omitempty"`
+	TxID      string             `json:"TxID"`
+	Timestamp time.Time          `json:"Timestamp"`
+	Creator   TxActor            `json:"Creator"`
+}
+
+// AuditFieldChange is a top level field whose value differs between the old and new row.
+// A missing value means that the field was absent.
+type AuditFieldChange struct {
+	Field string          `json:"Field"`
+	Old   json.RawMessage `json:"Old,omitempty"`
+	New   json.RawMessage `json:"New,omitempty"`
+}
+
+// GetAuditTrailByEntity returns the audit entries of one row in chronological order.
+func GetAuditTrailByEntity(stub shim.ChaincodeStubInterface, table_name string, row_keys []string) ([]AuditEntry, error) {
+	entityKey, err := json.Marshal(row_keys)
+	if err != nil {
+		return nil, fmt.Errorf("GetAuditTrailByEntity failed because json.Marshal failed with error %v", err)
+	}
+	return queryAuditEntries(stub, AuditPrefix, []string{table_name, string(entityKey)}, false, nil)
+}
+
+// GetAuditTrailByActor returns the audit entries written by one client in chronological order.
+func GetAuditTrailByActor(stub shim.ChaincodeStubInterface, mspID string, id string) ([]AuditEntry, error) {
+	return queryAuditEntries(stub, AuditActorPrefix, []string{mspID, id}, true, nil)
+}
+
+// GetAuditTrailByTimeRange returns the audit entries whose transaction timestamp lies in
+// [from, to), in chronological order. Entries are indexed per day, so the query costs
+// one partial composite key scan for every day of the range.
+func GetAuditTrailByTimeRange(stub shim.ChaincodeStubInterface, from time.Time, to time.Time) ([]AuditEntry, error) {
+	from, to = from.UTC(), to.UTC()
+	inRange := func(entry *AuditEntry) bool {
+		return !entry.Timestamp.Before(from) && entry.Timestamp.Before(to)
+	}
+
+	entries := make([]AuditEntry, 0)
+	for day := from.Truncate(24 * time.Hour); day.Before(to); day = day.Add(24 * time.Hour) {
+		dayEntries, err := queryAuditEntries(stub, AuditTimePrefix, []string{day.Format("2006-01-02")}, true, inRange)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, dayEntries...)
+	}
+	return entries, nil
+}
+
+// queryAuditEntries scans a partial composite key of the audit keys. If indirect is set,
+// the scanned values are the keys of the entries rather than the entries themselves.
+func queryAuditEntries(
+	stub shim.ChaincodeStubInterface,
+	prefix string,
+	attributes []string,
+	indirect bool,
+	filter func(*AuditEntry) bool,
+) ([]AuditEntry, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(prefix, attributes)
+	if err != nil {
+		return nil, fmt.Errorf("queryAuditEntries failed because stub.GetStateByPartialCompositeKey failed with error %v", err)
+	}
+	defer iterator.Close()
+
+	entries := make([]AuditEntry, 0)
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("queryAuditEntries failed because iterator.Next failed with error %v", err)
+		}
+		value := kv.Value
+		if indirect {
+			if value, err = stub.GetState(string(kv.Value)); err != nil {
+				return nil, fmt.Errorf("queryAuditEntries failed because stub.GetState failed with error %v", err)
+			}
+			if value == nil {
+				continue
+			}
+		}
+		var entry AuditEntry
+		if err = json.Unmarshal(value, &entry); err != nil {
+			return nil, fmt.Errorf("queryAuditEntries failed because json.Unmarshal failed with error %v", err)
+		}
+		if filter == nil || filter(&entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// recordAuditEntry stores the audit entry of a write and its index keys.
+func recordAuditEntry(
+	stub shim.ChaincodeStubInterface,
+	schema TableSchema,
+	table_name string,
+	composite_key string,
+	old_bytes []byte,
+	new_bytes []byte,
+) error {
+	if schema.Audit == AUDIT_OFF {
+		return nil
+	}
+
+	txTime, err := GetTxTime(stub)
+	if err != nil {
+		return err
+	}
+	actor, err := GetTxActor(stub)
+	if err != nil {
+		return err
+	}
+	_, row_keys, err := stub.SplitCompositeKey(composite_key)
+	if err != nil {
+		return fmt.Errorf("recordAuditEntry failed because stub.SplitCompositeKey failed with error %v", err)
+	}
+
+	entry := AuditEntry{
+		Table:     table_name,
+		RowKeys:   row_keys,
+		Operation: auditOperation(table_name, old_bytes, new_bytes),
+		OldHash:   hashRow(old_bytes),
+		NewHash:   hashRow(new_bytes),
+		TxID:      stub.GetTxID(),
+		Timestamp: txTime,
+		Creator:   actor,
+	}
+	if schema.Audit == AUDIT_DIFF {
+		if entry.Changes, err = diffRows(old_bytes, new_bytes); err != nil {
+			return err
+		}
+	}
+	entryBytes, err := json.Marshal(&entry)
+	if err != nil {
+		return fmt.Errorf("recordAuditEntry failed because json.Marshal failed with error %v", err)
+	}
+
+	entityKey, _ := json.Marshal(row_keys)
+	stamp := EncodeTimeKey(txTime)
+	entryKey, err := stub.CreateCompositeKey(AuditPrefix, []string{table_name, string(entityKey), stamp, entry.TxID})
+	if err != nil {
+		return fmt.Errorf("recordAuditEntry failed because stub.CreateCompositeKey failed with error %v", err)
+	}
+	actorKey, err := stub.CreateCompositeKey(AuditActorPrefix, []string{actor.MSPID, actor.ID, stamp, entry.TxID, table_name, string(entityKey)})
+	if err != nil {
+		return fmt.Errorf("recordAuditEntry failed because stub.CreateCompositeKey failed with error %v", err)
+	}
+	timeKey, err := stub.CreateCompositeKey(AuditTimePrefix, []string{txTime.Format("2006-01-02"), stamp, entry.TxID, table_name, string(entityKey)})
+	if err != nil {
+		return fmt.Errorf("recordAuditEntry failed because stub.CreateCompositeKey failed with error %v", err)
+	}
+
+	if err = stub.PutState(entryKey, entryBytes); err != nil {
+		return fmt.Errorf("recordAuditEntry failed because stub.PutState(%v) failed with error %v", entryKey, err)
+	}
+	if err = stub.PutState(actorKey, []byte(entryKey)); err != nil {
+		return fmt.Errorf("recordAuditEntry failed because stub.PutState(%v) failed with error %v", actorKey, err)
+	}
+	if err = stub.PutState(timeKey, []byte(entryKey)); err != nil {
+		return fmt.Errorf("recordAuditEntry failed because stub.PutState(%v) failed with error %v", timeKey, err)
+	}
+	return nil
+}
+
+// auditOperation classifies a write from the rows before and after it.
+func auditOperation(table_name string, old_bytes []byte, new_bytes []byte) string {
+	oldDeleted, newDeleted := isTombstoned(table_name, old_bytes), isTombstoned(table_name, new_bytes)
+	switch {
+	case old_bytes == nil:
+		return AUDIT_INSERT
+	case new_bytes == nil && oldDeleted:
+		return AUDIT_PURGE
+	case new_bytes == nil || (newDeleted && !oldDeleted):
+		return AUDIT_DELETE
+	case oldDeleted && !newDeleted:
+		return AUDIT_RESTORE
+	default:
+		return AUDIT_UPDATE
+	}
+}
+
+func hashRow(row_bytes []byte) string {
+	if row_bytes == nil {
+		return ""
+	}
+	sum := sha256.Sum256(row_bytes)
+	return hex.EncodeToString(sum[:])
+}
+
+// diffRows lists the top level fields that differ between two rows, sorted by field name.
+func diffRows(old_bytes []byte, new_bytes []byte) ([]AuditFieldChange, error) {
+	var oldRow, newRow map[string]json.RawMessage
+	if old_bytes != nil {
+		if err := json.Unmarshal(old_bytes, &oldRow); err != nil {
+			return nil, fmt.Errorf("diffRows failed because json.Unmarshal failed with error %v", err)
+		}
+	}
+	if new_bytes != nil {
+		if err := json.Unmarshal(new_bytes, &newRow); err != nil {
+			return nil, fmt.Errorf("diffRows failed because json.Unmarshal failed with error %v", err)
+		}
+	}
+
+	fields := make([]string, 0, len(oldRow)+len(newRow))
+	for field := range oldRow {
+		fields = append(fields, field)
+	}
+	for field := range newRow {
+		if _, found := oldRow[field]; !found {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	changes := make([]AuditFieldChange, 0)
+	for _, field := range fields {
+		oldValue, newValue := oldRow[field], newRow[field]
+		if bytes.Equal(oldValue, newValue) {
+			continue
+		}
+		changes = append(changes, AuditFieldChange{Field: field, Old: oldValue, New: newValue})
+	}
+	return changes, nil
+}
@@ -0,0 +1,525 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Akachain/akc-go-sdk-v2/common"
+	"github.com/Knetic/govaluate"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Business rules are govaluate expressions stored in the ledger, so that they can be changed
+// by administrators without upgrading the chaincode. A rule belongs to a table whose schema
+// enables Rules and must evaluate to true for every row inserted or updated in the table.
+//
+// The expression sees the fields of the row, nested fields being flattened to dotted names
+// that are written between brackets, e.g. "[Shipping.Country] != 'XX'", and the parameters
+// of the rule, which shadow fields of the same name. Numbers are float64.
+//
+// Every change of a rule creates a new version. The current version is stored under
+// RulePrefix and every version is kept under RuleVersionPrefix.
+const (
+	RulePrefix        = "AKC~RULE"
+	RuleVersionPrefix = "AKC~RULE~VERSION"
+)
+
+// This is effectively a strongly typed enum declaration.
+type RuleParameterType string
+
+const (
+	RULE_NUMBER RuleParameterType = "number"
+	RULE_STRING RuleParameterType = "string"
+	RULE_BOOL   RuleParameterType = "bool"
+	RULE_LIST   RuleParameterType = "list" // a JSON array, to be used with the IN operator
+)
+
+// RuleParameter is a typed constant of a rule, e.g. the maximum amount of an order.
+type RuleParameter struct {
+	Type  RuleParameterType `json:"Type"`
+	Value json.RawMessage   `json:"Value"`
+}
+
+// BusinessRule is a rule as stored in the ledger. Version, UpdatedBy, UpdatedAt and
+// UpdateTxID are managed by SaveBusinessRule.
+type BusinessRule struct {
+	Table      string                   `json:"Table"`
+	Name       string                   `json:"Name"`
+	Expression string                   `json:"Expression"`
+	Parameters map[string]RuleParameter `json:"Parameters,omitempty"`
+	Message    string                   `json:"Message,omitempty"` // reported when the rule is violated
+	Disabled   bool                     `json:"Disabled,omitempty"`
+	Version    uint64                   `json:"Version"`
+	UpdatedBy  TxActor                  `json:"UpdatedBy"`
+	UpdatedAt  time.Time                `json:"UpdatedAt"`
+	UpdateTxID string                   `json:"UpdateTxID"`
+}
+
+// RuleViolation describes a rule that a row does not satisfy. Values holds the values of
+// the variables used by the expression, and Error is set if the expression could not be
+// evaluated, e.g. because the row lacks a field.
+type RuleViolation struct {
+	Rule       string                 `json:"Rule"`
+	Version    uint64                 `json:"Version"`
+	Expression string                 `json:"Expression"`
+	Message    string                 `json:"Message,omitempty"`
+	Values     map[string]interface{} `json:"Values,omitempty"`
+	Error      string                 `json:"Error,omitempty"`
+}
+
+// RuleViolationError is returned by the util writes when a row violates business rules.
+// All the violated rules are reported, not only the first one.
+type RuleViolationError struct {
+	Table      string
+	RowKeys    []string
+	Violations []RuleViolation
+}
+
+func (e *RuleViolationError) Error() string {
+	names := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		names[i] = violation.Rule
+	}
+	return fmt.Sprintf("the row of table %s with keys %v violates business rules %s", e.Table, e.RowKeys, strings.Join(names, ", "))
+}
+
+// IsRuleViolation reports whether err, or an error it wraps, is a RuleViolationError.
+func IsRuleViolation(err error) bool {
+	var violation *RuleViolationError
+	return errors.As(err, &violation)
+}
+
+// RespondRuleViolation formats a RuleViolationError into a Fabric error response whose
+// message carries the violations as details. Other errors are reported with ERR5.
+func RespondRuleViolation(err error) peer.Response {
+	var violation *RuleViolationError
+	if !errors.As(err, &violation) {
+		return common.RespondError(common.ResponseError{ResCode: common.ERR5, Msg: fmt.Sprintf("%s %s", common.ResCodeDict[common.ERR5], err.Error())})
+	}
+	return common.RespondError(common.ResponseError{
+		ResCode: common.ERR19,
+		Msg:     fmt.Sprintf("%s %s", common.ResCodeDict[common.ERR19], violation.Error()),
+		Details: violation.Violations,
+	})
+}
+
+// RuleAdminCheck decides whether the creator of the transaction may change business rules.
+type RuleAdminCheck func(stub shim.ChaincodeStubInterface) error
+
+var (
+	ruleAdminCheckLock sync.RWMutex
+	ruleAdminCheck     RuleAdminCheck
+)
+
+// SetRuleAdminCheck sets the check run by SaveBusinessRule and DisableBusinessRule. Until it
+// is called, nobody can change rules.
+func SetRuleAdminCheck(check RuleAdminCheck) {
+	ruleAdminCheckLock.Lock()
+	defer ruleAdminCheckLock.Unlock()
+	ruleAdminCheck = check
+}
+
+// RuleAdminsByMSP returns a RuleAdminCheck that lets the clients of the given MSPs change rules.
+func RuleAdminsByMSP(mspIDs ...string) RuleAdminCheck {
+	return func(stub shim.ChaincodeStubInterface) error {
+		actor, err := GetTxActor(stub)
+		if err != nil {
+			return err
+		}
+		for _, mspID := range mspIDs {
+			if actor.MSPID == mspID {
+				return nil
+			}
+		}
+		return fmt.Errorf("clients of MSP %q cannot administer business rules", actor.MSPID)
+	}
+}
+
+func checkRuleAdmin(stub shim.ChaincodeStubInterface) error {
+	ruleAdminCheckLock.RLock()
+	check := ruleAdminCheck
+	ruleAdminCheckLock.RUnlock()
+	if check == nil {
+		return fmt.Errorf("no rule administrator check has been set, see SetRuleAdminCheck")
+	}
+	return check(stub)
+}
+
+// SaveBusinessRule creates or replaces a rule after checking that the creator is a rule
+// administrator, that the expression compiles and that the parameters match their types.
+// It returns the rule as stored, with its new version.
+func SaveBusinessRule(stub shim.ChaincodeStubInterface, rule BusinessRule) (BusinessRule, error) {
+	if err := checkRuleAdmin(stub); err != nil {
+		return BusinessRule{}, fmt.Errorf("SaveBusinessRule failed because checkRuleAdmin failed with error %v", err)
+	}
+	if rule.Table == "" || rule.Name == "" {
+		return BusinessRule{}, fmt.Errorf("SaveBusinessRule failed because the rule has no table or no name")
+	}
+	if _, err := govaluate.NewEvaluableExpression(rule.Expression); err != nil {
+		return BusinessRule{}, fmt.Errorf("SaveBusinessRule failed because expression %q does not compile: %v", rule.Expression, err)
+	}
+	if _, err := ruleParameterValues(rule.Parameters); err != nil {
+		return BusinessRule{}, fmt.Errorf("SaveBusinessRule failed because %v", err)
+	}
+
+	current, found, err := GetBusinessRule(stub, rule.Table, rule.Name)
+	if err != nil {
+		return BusinessRule{}, err
+	}
+	rule.Version = 1
+	if found {
+		rule.Version = current.Version + 1
+	}
+	if rule.UpdatedBy, err = GetTxActor(stub); err != nil {
+		return BusinessRule{}, err
+	}
+	if rule.UpdatedAt, err = GetTxTime(stub); err != nil {
+		return BusinessRule{}, err
+	}
+	rule.UpdateTxID = stub.GetTxID()
+
+	ruleBytes, err := json.Marshal(&rule)
+	if err != nil {
+		return BusinessRule{}, fmt.Errorf("SaveBusinessRule failed because json.Marshal failed with error %v", err)
+	}
+	ruleKey, err := stub.CreateCompositeKey(RulePrefix, []string{rule.Table, rule.Name})
+	if err != nil {
+		return BusinessRule{}, fmt.Errorf("SaveBusinessRule failed because stub.CreateCompositeKey failed with error %v", err)
+	}
+	versionKey, err := stub.CreateCompositeKey(RuleVersionPrefix, []string{rule.Table, rule.Name, EncodeUintKey(rule.Version)})
+	if err != nil {
+		return BusinessRule{}, fmt.Errorf("SaveBusinessRule failed because stub.CreateCompositeKey failed with error %v", err)
+	}
+	if err = stub.PutState(ruleKey, ruleBytes); err != nil {
+		return BusinessRule{}, fmt.Errorf("SaveBusinessRule failed because stub.PutState(%v) failed with error %v", ruleKey, err)
+	}
+	if err = stub.PutState(versionKey, ruleBytes); err != nil {
+		return BusinessRule{}, fmt.Errorf("SaveBusinessRule failed because stub.PutState(%v) failed with error %v", versionKey, err)
+	}
+	return rule, nil
+}
+
+// DisableBusinessRule saves a new version of a rule that is no longer evaluated.
+func DisableBusinessRule(stub shim.ChaincodeStubInterface, table_name string, name string) (BusinessRule, error) {
+	rule, found, err := GetBusinessRule(stub, table_name, name)
+	if err != nil {
+		return BusinessRule{}, err
+	}
+	if !found {
+		return BusinessRule{}, fmt.Errorf("DisableBusinessRule failed because rule %s of table %s does not exist", name, table_name)
+	}
+	rule.Disabled = true
+	return SaveBusinessRule(stub, rule)
+}
+
+// GetBusinessRule returns the current version of a rule.
+func GetBusinessRule(stub shim.ChaincodeStubInterface, table_name string, name string) (rule BusinessRule, found bool, err error) {
+	found, err = readRuleKey(stub, RulePrefix, []string{table_name, name}, &rule)
+	return
+}
+
+// GetBusinessRuleVersion returns a given version of a rule.
+func GetBusinessRuleVersion(stub shim.ChaincodeStubInterface, table_name string, name string, version uint64) (rule BusinessRule, found bool, err error) {
+	found, err = readRuleKey(stub, RuleVersionPrefix, []string{table_name, name, EncodeUintKey(version)}, &rule)
+	return
+}
+
+func readRuleKey(stub shim.ChaincodeStubInterface, prefix string, attributes []string, rule *BusinessRule) (bool, error) {
+	key, err := stub.CreateCompositeKey(prefix, attributes)
+	if err != nil {
+		return false, fmt.Errorf("readRuleKey failed because stub.CreateCompositeKey failed with error %v", err)
+	}
+	bytes, err := stub.GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("readRuleKey failed because stub.GetState(%v) failed with error %v", key, err)
+	}
+	if bytes == nil {
+		return false, nil
+	}
+	if err = json.Unmarshal(bytes, rule); err != nil {
+		return false, fmt.Errorf("readRuleKey failed because json.Unmarshal failed with error %v", err)
+	}
+	return true, nil
+}
+
+// ListBusinessRules returns the current version of every rule of a table, disabled ones included.
+func ListBusinessRules(stub shim.ChaincodeStubInterface, table_name string) ([]BusinessRule, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(RulePrefix, []string{table_name})
+	if err != nil {
+		return nil, fmt.Errorf("ListBusinessRules failed because stub.GetStateByPartialCompositeKey failed with error %v", err)
+	}
+	defer iterator.Close()
+
+	rules := make([]BusinessRule, 0)
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("ListBusinessRules failed because iterator.Next failed with error %v", err)
+		}
+		var rule BusinessRule
+		if err = json.Unmarshal(kv.Value, &rule); err != nil {
+			return nil, fmt.Errorf("ListBusinessRules failed because json.Unmarshal failed with error %v", err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// EvaluateBusinessRules evaluates the enabled rules of a table against a JSON document and
+// returns the rules it violates. It does not need the table schema to enable Rules, so it
+// can be used to validate a document before writing it.
+func EvaluateBusinessRules(stub shim.ChaincodeStubInterface, table_name string, row_bytes []byte) ([]RuleViolation, error) {
+	rules, err := ListBusinessRules(stub, table_name)
+	if err != nil {
+		return nil, err
+	}
+	row, err := decodeRow(row_bytes)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	flattenRuleFields("", row, fields)
+
+	violations := make([]RuleViolation, 0)
+	for _, rule := range rules {
+		if rule.Disabled {
+			continue
+		}
+		if violation, violated := evaluateBusinessRule(rule, fields); violated {
+			violations = append(violations, violation)
+		}
+	}
+	return violations, nil
+}
+
+func evaluateBusinessRule(rule BusinessRule, fields map[string]interface{}) (RuleViolation, bool) {
+	violation := RuleViolation{Rule: rule.Name, Version: rule.Version, Expression: rule.Expression, Message: rule.Message}
+
+	expression, err := govaluate.NewEvaluableExpression(rule.Expression)
+	if err != nil {
+		violation.Error = err.Error()
+		return violation, true
+	}
+	parameters, err := ruleParameterValues(rule.Parameters)
+	if err != nil {
+		violation.Error = err.Error()
+		return violation, true
+	}
+	variables := make(map[string]interface{}, len(fields)+len(parameters))
+	for name, value := range fields {
+		variables[name] = value
+	}
+	for name, value := range parameters {
+		variables[name] = value
+	}
+
+	result, err := expression.Evaluate(variables)
+	if err == nil {
+		if satisfied, ok := result.(bool); ok && satisfied {
+			return violation, false
+		} else if !ok {
+			err = fmt.Errorf("the expression returned %v instead of a boolean", result)
+		}
+	}
+	if err != nil {
+		violation.Error = err.Error()
+	}
+	violation.Values = make(map[string]interface{})
+	for _, name := range expression.Vars() {
+		if value, found := variables[name]; found {
+			violation.Values[name] = value
+		}
+	}
+	return violation, true
+}
+
+// checkBusinessRules rejects rows that violate the rules of tables whose schema enables Rules.
+// Deletes are not checked, nor are restores: a restored row is the row that was stored, and
+// the rules saved since it was deleted must not prevent bringing it back.
+func checkBusinessRules(
+	stub shim.ChaincodeStubInterface,
+	schema TableSchema,
+	table_name string,
+	composite_key string,
+	old_bytes []byte,
+	new_bytes []byte,
+) error {
+	if !schema.Rules || new_bytes == nil || isTombstoned(table_name, new_bytes) || isTombstoned(table_name, old_bytes) {
+		return nil
+	}
+	violations, err := EvaluateBusinessRules(stub, table_name, new_bytes)
+	if err != nil {
+		return fmt.Errorf("checkBusinessRules failed because EvaluateBusinessRules failed with error %v", err)
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	_, row_keys, err := stub.SplitCompositeKey(composite_key)
+	if err != nil {
+		return fmt.Errorf("checkBusinessRules failed because stub.SplitCompositeKey failed with error %v", err)
+	}
+	return &RuleViolationError{Table: table_name, RowKeys: row_keys, Violations: violations}
+}
+
+// flattenRuleFields turns a decoded row into govaluate variables: nested objects become
+// dotted names and numbers become float64.
+func flattenRuleFields(prefix string, object map[string]interface{}, fields map[string]interface{}) {
+	for name, value := range object {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flattenRuleFields(prefix+name+".", v, fields)
+		default:
+			fields[prefix+name] = ruleValue(v)
+		}
+	}
+}
+
+func ruleValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = ruleValue(v[i])
+		}
+		return values
+	default:
+		return v
+	}
+}
+
+// ruleParameterValues decodes the parameters of a rule and checks their types.
+func ruleParameterValues(parameters map[string]RuleParameter) (map[string]interface{}, error) {
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := make(map[string]interface{}, len(parameters))
+	for _, name := range names {
+		parameter := parameters[name]
+		value, err := decodeJSONValue(parameter.Value)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s is not valid JSON: %v", name, err)
+		}
+		var ok bool
+		switch parameter.Type {
+		case RULE_NUMBER:
+			_, ok = value.(json.Number)
+		case RULE_STRING:
+			_, ok = value.(string)
+		case RULE_BOOL:
+			_, ok = value.(bool)
+		case RULE_LIST:
+			_, ok = value.([]interface{})
+		default:
+			return nil, fmt.Errorf("parameter %s has unknown type %q", name, parameter.Type)
+		}
+		if !ok {
+			return nil, fmt.Errorf("parameter %s is not a %s: %s", name, parameter.Type, parameter.Value)
+		}
+		values[name] = ruleValue(value)
+	}
+	return values, nil
+}
+
+// BusinessRuleAdminInvoke exposes the administration of business rules as chaincode functions,
+// to be dispatched from the Invoke of a chaincode:
+//
+//	SaveBusinessRule       [rule JSON]
+//	DisableBusinessRule    [table, name]
+//	GetBusinessRule        [table, name]
+//	GetBusinessRuleVersion [table, name, version]
+//	ListBusinessRules      [table]
+//
+// The functions that change rules run the check set with SetRuleAdminCheck.
+func BusinessRuleAdminInvoke(stub shim.ChaincodeStubInterface, function string, args []string) peer.Response {
+	var result interface{}
+	var err error
+	var found = true
+	resCode := common.ERR4
+
+	switch function {
+	case "SaveBusinessRule":
+		if err = CheckChaincodeFunctionCallWellFormedness(args, 1); err != nil {
+			break
+		}
+		var rule BusinessRule
+		if err = json.Unmarshal([]byte(args[0]), &rule); err != nil {
+			break
+		}
+		result, err = SaveBusinessRule(stub, rule)
+		resCode = common.ERR5
+	case "DisableBusinessRule":
+		if err = CheckChaincodeFunctionCallWellFormedness(args, 2); err != nil {
+			break
+		}
+		result, err = DisableBusinessRule(stub, args[0], args[1])
+		resCode = common.ERR5
+	case "GetBusinessRule":
+		if err = CheckChaincodeFunctionCallWellFormedness(args, 2); err != nil {
+			break
+		}
+		result, found, err = GetBusinessRule(stub, args[0], args[1])
+	case "GetBusinessRuleVersion":
+		if err = CheckChaincodeFunctionCallWellFormedness(args, 3); err != nil {
+			break
+		}
+		var version uint64
+		if version, err = strconv.ParseUint(args[2], 10, 64); err != nil {
+			break
+		}
+		result, found, err = GetBusinessRuleVersion(stub, args[0], args[1], version)
+	case "ListBusinessRules":
+		if err = CheckChaincodeFunctionCallWellFormedness(args, 1); err != nil {
+			break
+		}
+		result, err = ListBusinessRules(stub, args[0])
+	default:
+		err = fmt.Errorf("unknown business rule function %s", function)
+	}
+
+	if err == nil && !found {
+		err = fmt.Errorf("the business rule does not exist")
+	}
+	if err != nil {
+		resErr := common.ResponseError{ResCode: resCode, Msg: fmt.Sprintf("%s %s %s", common.ResCodeDict[resCode], err.Error(), common.GetLine())}
+		return common.RespondError(resErr)
+	}
+	bytes, err := json.Marshal(result)
+	if err != nil {
+		resErr := common.ResponseError{ResCode: common.ERR3, Msg: fmt.Sprintf("%s %s %s", common.ResCodeDict[common.ERR3], err.Error(), common.GetLine())}
+		return common.RespondError(resErr)
+	}
+	resSuc := common.ResponseSuccess{ResCode: common.SUCCESS, Msg: common.ResCodeDict[common.SUCCESS], Payload: string(bytes)}
+	return common.RespondSuccess(resSuc)
+}
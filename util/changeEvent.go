@@ -0,0 +1,207 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// This is effectively a strongly typed enum declaration.
+type EventMode uint8
+
+const (
+	EVENT_OFF   EventMode = 0 // writes are not reported
+	EVENT_VALUE EventMode = 1 // changes carry the new value of the row
+	EVENT_DIFF  EventMode = 2 // changes also carry the top level fields that changed
+)
+
+// ChangeEventName is the name of the chaincode event that reports the writes made on tables
+// whose schema enables Events.
+//
+// Fabric keeps a single event per transaction: every call to SetEvent replaces the previous
+// one. util therefore collects the changes of the invocation and FlushChangeEvents emits them
+// as one event, which the chaincode calls once, after its last write and before it returns.
+// The payload is a ChangeEventBatch in JSON:
+//
+//	{
+//	  "TxID": "<transaction ID>",
+//	  "Changes": [
+//	    {
+//	      "Table": "ORDER",
+//	      "RowKeys": ["o1"],
+//	      "Operation": "INSERT" | "UPDATE" | "DELETE" | "RESTORE" | "PURGE",
+//	      "Value": { ... },                                  // the new row, absent for DELETE and PURGE
+//	      "Changes": [{"Field": "Status", "Old": ..., "New": ...}], // EVENT_DIFF only
+//	      "Transition": "send"                               // set by StateMachine.Apply
+//	    }
+//	  ]
+//	}
+//
+// Changes are listed in the order the rows were first written. A row written several times
+// by the transaction appears once, with its last value, like in the ledger.
+//
+// An event set by the chaincode after FlushChangeEvents replaces the batch, and changes
+// made after FlushChangeEvents are only reported if it is called again.
+const ChangeEventName = "AKC~CHANGES"
+
+// ChangeEventBatch is the payload of the ChangeEventName event.
+type ChangeEventBatch struct {
+	TxID    string        `json:"TxID"`
+	Changes []ChangeEvent `json:"Changes"`
+}
+
+// ChangeEvent reports a write on a row. Operation is one of the AUDIT_ operations.
+type ChangeEvent struct {
+	Table      string             `json:"Table"`
+	RowKeys    []string           `json:"RowKeys"`
+	Operation  string             `json:"Operation"`
+	Value      json.RawMessage    `json:"Value,omitempty"`
+	Changes    []AuditFieldChange `json:"Changes,omitempty"`
+	Transition string             `json:"Transition,omitempty"`
+}
+
+// DecodeChangeEvents decodes the payload of a ChangeEventName event, e.g. in an off-chain listener.
+func DecodeChangeEvents(payload []byte) (ChangeEventBatch, error) {
+	var batch ChangeEventBatch
+	if err := json.Unmarshal(payload, &batch); err != nil {
+		return ChangeEventBatch{}, fmt.Errorf("DecodeChangeEvents failed because json.Unmarshal failed with error %v", err)
+	}
+	return batch, nil
+}
+
+// ChangesOf returns the changes of the batch made on one table.
+func (batch ChangeEventBatch) ChangesOf(table_name string) []ChangeEvent {
+	changes := make([]ChangeEvent, 0)
+	for _, change := range batch.Changes {
+		if change.Table == table_name {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// DecodeValue unmarshals the new value of the row into row_value. It returns false if the
+// change carries no value, i.e. for deletes.
+func (change ChangeEvent) DecodeValue(row_value interface{}) (bool, error) {
+	if change.Value == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(change.Value, row_value); err != nil {
+		return false, fmt.Errorf("DecodeValue failed because json.Unmarshal failed with error %v", err)
+	}
+	return true, nil
+}
+
+// txChanges is what a transaction remembers of its change events.
+type txChanges struct {
+	batch ChangeEventBatch
+	index map[string]int // composite key -> position in batch.Changes
+}
+
+// recordChangeEvent adds a write to the change events of the invocation.
+func recordChangeEvent(
+	stub shim.ChaincodeStubInterface,
+	schema TableSchema,
+	table_name string,
+	composite_key string,
+	old_bytes []byte,
+	new_bytes []byte,
+) error {
+	if schema.Events == EVENT_OFF {
+		return nil
+	}
+	_, row_keys, err := stub.SplitCompositeKey(composite_key)
+	if err != nil {
+		return fmt.Errorf("recordChangeEvent failed because stub.SplitCompositeKey failed with error %v", err)
+	}
+
+	change := ChangeEvent{
+		Table:     table_name,
+		RowKeys:   row_keys,
+		Operation: auditOperation(table_name, old_bytes, new_bytes),
+	}
+	// A soft deleted row is still stored but it is no longer a value of the table
+	if change.Operation != AUDIT_DELETE && change.Operation != AUDIT_PURGE {
+		change.Value = json.RawMessage(new_bytes)
+	}
+	if schema.Events == EVENT_DIFF {
+		if change.Changes, err = diffRows(old_bytes, new_bytes); err != nil {
+			return err
+		}
+	}
+
+	changes := getTxChanges(stub)
+	if position, found := changes.index[composite_key]; found {
+		// A row inserted by the transaction is still new to the ledger
+		if changes.batch.Changes[position].Operation == AUDIT_INSERT && change.Operation == AUDIT_UPDATE {
+			change.Operation = AUDIT_INSERT
+		}
+		changes.batch.Changes[position] = change
+	} else {
+		changes.index[composite_key] = len(changes.batch.Changes)
+		changes.batch.Changes = append(changes.batch.Changes, change)
+	}
+	return nil
+}
+
+// annotateChangeEvent names the state transition that produced the change of a row.
+// It reports whether the row has a change event to annotate.
+func annotateChangeEvent(stub shim.ChaincodeStubInterface, composite_key string, transition string) bool {
+	changes := getTxChanges(stub)
+	position, found := changes.index[composite_key]
+	if !found {
+		return false
+	}
+	changes.batch.Changes[position].Transition = transition
+	return true
+}
+
+func getTxChanges(stub shim.ChaincodeStubInterface) *txChanges {
+	if remembered, found := recallTxValue(stub, ChangeEventName); found {
+		return remembered.(*txChanges)
+	}
+	changes := &txChanges{
+		batch: ChangeEventBatch{TxID: stub.GetTxID(), Changes: make([]ChangeEvent, 0)},
+		index: make(map[string]int),
+	}
+	rememberTxValue(stub, ChangeEventName, changes)
+	return changes
+}
+
+// FlushChangeEvents sets the ChangeEventName event reporting the changes made so far by the
+// invocation on tables whose schema enables Events. Chaincode calls it once before returning.
+// Nothing is set if there is no change to report.
+func FlushChangeEvents(stub shim.ChaincodeStubInterface) error {
+	changes := getTxChanges(stub)
+	if len(changes.batch.Changes) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(&changes.batch)
+	if err != nil {
+		return fmt.Errorf("FlushChangeEvents failed because json.Marshal failed with error %v", err)
+	}
+	if err = stub.SetEvent(ChangeEventName, payload); err != nil {
+		return fmt.Errorf("FlushChangeEvents failed because stub.SetEvent failed with error %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// This is effectively a strongly typed enum declaration.
+type PredicateOperator uint8
+
+const (
+	FIELD_EQUALS           PredicateOperator = 0
+	FIELD_NOT_EQUALS       PredicateOperator = 1
+	FIELD_LESS_THAN        PredicateOperator = 2
+	FIELD_LESS_OR_EQUAL    PredicateOperator = 3
+	FIELD_GREATER_THAN     PredicateOperator = 4
+	FIELD_GREATER_OR_EQUAL PredicateOperator = 5
+	FIELD_EXISTS           PredicateOperator = 6 // the field is present, even with a null value
+	FIELD_MISSING          PredicateOperator = 7
+	FIELD_IN               PredicateOperator = 8 // Value must be a slice of the allowed values
+)
+
+var predicateOperatorSymbols = map[PredicateOperator]string{
+	FIELD_EQUALS:           "==",
+	FIELD_NOT_EQUALS:       "!=",
+	FIELD_LESS_THAN:        "<",
+	FIELD_LESS_OR_EQUAL:    "<=",
+	FIELD_GREATER_THAN:     ">",
+	FIELD_GREATER_OR_EQUAL: ">=",
+	FIELD_EXISTS:           "exists",
+	FIELD_MISSING:          "is missing",
+	FIELD_IN:               "in",
+}
+
+// FieldPredicate is a condition on one field of the row currently stored. Field is a top
+// level JSON field or a dotted path to a nested one, e.g. "Shipping.Country". Value is
+// compared to the field as JSON: numbers by value, strings byte by byte. Ranges are
+// expressed with two predicates, e.g. Amount >= 10 and Amount < 100.
+type FieldPredicate struct {
+	Field    string
+	Operator PredicateOperator
+	Value    interface{}
+}
+
+func (predicate FieldPredicate) String() string {
+	symbol, found := predicateOperatorSymbols[predicate.Operator]
+	if !found {
+		symbol = fmt.Sprintf("operator(%d)", predicate.Operator)
+	}
+	if predicate.Operator == FIELD_EXISTS || predicate.Operator == FIELD_MISSING {
+		return predicate.Field + " " + symbol
+	}
+	return fmt.Sprintf("%s %s %s", predicate.Field, symbol, jsonString(predicate.Value))
+}
+
+// PreconditionFailedError is returned by the conditional writes when the stored row does not
+// satisfy one of the predicates. Actual holds the value of the field in the stored row, and
+// ActualFound is false if the row does not have the field.
+type PreconditionFailedError struct {
+	Table       string
+	RowKeys     []string
+	Predicate   FieldPredicate
+	Actual      interface{}
+	ActualFound bool
+}
+
+func (e *PreconditionFailedError) Error() string {
+	actual := "missing"
+	if e.ActualFound {
+		actual = jsonString(e.Actual)
+	}
+	return fmt.Sprintf("precondition %v failed on the row of table %s with keys %v: %s is %s",
+		e.Predicate, e.Table, e.RowKeys, e.Predicate.Field, actual)
+}
+
+// IsPreconditionFailed reports whether err, or an error it wraps, is a PreconditionFailedError.
+func IsPreconditionFailed(err error) bool {
+	var precondition *PreconditionFailedError
+	return errors.As(err, &precondition)
+}
+
+// UpdateTableRowIf works like UpdateTableRow but only writes the row if it exists and the
+// stored row satisfies all the predicates (compare-and-set). Otherwise the row is left
+// untouched and the first failing predicate is reported as a PreconditionFailedError.
+func UpdateTableRowIf(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	row_keys []string,
+	new_row_value interface{},
+	predicates []FieldPredicate,
+) error {
+	_, row_bytes, err := checkTableRowPredicates(stub, table_name, row_keys, predicates)
+	if err != nil {
+		return fmt.Errorf("UpdateTableRowIf failed because %w", err)
+	}
+	return updateTableRow(stub, table_name, row_keys, new_row_value, row_bytes)
+}
+
+// DeleteTableRowIf works like DeleteTableRow with FAIL_IF_MISSING but only deletes the row if
+// the stored row satisfies all the predicates.
+func DeleteTableRowIf(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	row_keys []string,
+	predicates []FieldPredicate,
+	old_row_value interface{},
+) error {
+	composite_key, row_bytes, err := checkTableRowPredicates(stub, table_name, row_keys, predicates)
+	if err != nil {
+		return fmt.Errorf("DeleteTableRowIf failed because %w", err)
+	}
+	if !InterfaceIsNilOrIsZeroOfUnderlyingType(old_row_value) {
+		err = json.Unmarshal(row_bytes, old_row_value)
+		if err != nil {
+			return fmt.Errorf("DeleteTableRowIf failed because json.Unmarshal failed with error %v", err)
+		}
+	}
+	err = deleteTableRow(stub, table_name, composite_key, row_bytes)
+	if err != nil {
+		return fmt.Errorf("DeleteTableRowIf failed because %w", err)
+	}
+	return nil
+}
+
+// checkTableRowPredicates reads the row and evaluates the predicates against it. It returns
+// the composite key and the bytes of the row, so that the write does not read it again.
+func checkTableRowPredicates(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	row_keys []string,
+	predicates []FieldPredicate,
+) (composite_key string, row_bytes []byte, err error) {
+	composite_key, row_bytes, _, err = getTableRowAndCompositeKey(stub, table_name, row_keys, nil, FAIL_IF_MISSING, EXCLUDE_DELETED)
+	if err != nil {
+		return
+	}
+	row, err := decodeRow(row_bytes)
+	if err != nil {
+		return
+	}
+
+	for _, predicate := range predicates {
+		actual, found := lookupField(row, predicate.Field)
+		satisfied, evalErr := evaluatePredicate(predicate, actual, found)
+		if evalErr != nil {
+			err = fmt.Errorf("predicate %v could not be evaluated: %v", predicate, evalErr)
+			return
+		}
+		if !satisfied {
+			err = &PreconditionFailedError{
+				Table:       table_name,
+				RowKeys:     row_keys,
+				Predicate:   predicate,
+				Actual:      actual,
+				ActualFound: found,
+			}
+			return
+		}
+	}
+	return
+}
+
+// lookupField follows a dotted path through the objects of a decoded row.
+func lookupField(row map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = row
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func evaluatePredicate(predicate FieldPredicate, actual interface{}, found bool) (bool, error) {
+	switch predicate.Operator {
+	case FIELD_EXISTS:
+		return found, nil
+	case FIELD_MISSING:
+		return !found, nil
+	}
+
+	expected, err := normalizeJSONValue(predicate.Value)
+	if err != nil {
+		return false, err
+	}
+	switch predicate.Operator {
+	case FIELD_EQUALS:
+		return found && jsonValuesEqual(actual, expected), nil
+	case FIELD_NOT_EQUALS:
+		return !found || !jsonValuesEqual(actual, expected), nil
+	case FIELD_IN:
+		allowed, ok := expected.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("the value of FIELD_IN must be a slice")
+		}
+		for _, value := range allowed {
+			if found && jsonValuesEqual(actual, value) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case FIELD_LESS_THAN, FIELD_LESS_OR_EQUAL, FIELD_GREATER_THAN, FIELD_GREATER_OR_EQUAL:
+		if !found {
+			return false, nil
+		}
+		order, comparable := compareJSONValues(actual, expected)
+		if !comparable {
+			return false, nil
+		}
+		switch predicate.Operator {
+		case FIELD_LESS_THAN:
+			return order < 0, nil
+		case FIELD_LESS_OR_EQUAL:
+			return order <= 0, nil
+		case FIELD_GREATER_THAN:
+			return order > 0, nil
+		default:
+			return order >= 0, nil
+		}
+	default:
+		return false, fmt.Errorf("operator %d is unknown", predicate.Operator)
+	}
+}
+
+// normalizeJSONValue turns a Go value into the form it has once decoded from a stored row.
+func normalizeJSONValue(value interface{}) (interface{}, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSONValue(raw)
+}
+
+// compareJSONValues orders two numbers or two strings. Values of other or mixed types are
+// not comparable.
+func compareJSONValues(a interface{}, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return 0, false
+		}
+		xr, xok := new(big.Rat).SetString(x.String())
+		yr, yok := new(big.Rat).SetString(y.String())
+		if !xok || !yok {
+			return 0, false
+		}
+		return xr.Cmp(yr), true
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package util
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// CounterPrefix is the object type of the keys that hold counters.
+//
+// A counter is never updated in place. Every AddToCounter writes its delta under its own
+// key (name, txID, sequence), where sequence numbers the calls made for the counter by the
+// invocation from 0, so every simulation of the transaction writes the same keys. Concurrent
+// transactions that only add to a counter never read or write the same key and cannot
+// invalidate each other with MVCC_READ_CONFLICT.
+// The value is the sum of all the keys under (name). CompactCounter folds the deltas into
+// the base key (name, "") to keep that sum cheap.
+//
+// Reading the value scans the whole counter, so a transaction that reads the counter
+// conflicts with any concurrent one that adds to it. Keep reads in query transactions.
+const CounterPrefix = "AKC~COUNTER"
+
+// AddToCounter adds delta (which may be negative) to a counter.
+func AddToCounter(stub shim.ChaincodeStubInterface, name string, delta int64) error {
+	sequence := nextTxSequence(stub, CounterPrefix+name)
+	deltaKey, err := stub.CreateCompositeKey(CounterPrefix, []string{name, stub.GetTxID(), strconv.FormatUint(sequence, 10)})
+	if err != nil {
+		return fmt.Errorf("AddToCounter failed because stub.CreateCompositeKey failed with error %v", err)
+	}
+	err = stub.PutState(deltaKey, []byte(strconv.FormatInt(delta, 10)))
+	if err != nil {
+		return fmt.Errorf("AddToCounter failed because stub.PutState(%v) failed with error %v", deltaKey, err)
+	}
+	return nil
+}
+
+// GetCounterValue returns the current value of a counter. A counter that has never been
+// added to is 0.
+func GetCounterValue(stub shim.ChaincodeStubInterface, name string) (int64, error) {
+	value, _, err := scanCounter(stub, name)
+	return value, err
+}
+
+// CompactCounter folds all the deltas of a counter into its base key and returns the value.
+// Compaction conflicts with transactions adding to the counter in the same block, so it is
+// best run periodically from a dedicated transaction.
+func CompactCounter(stub shim.ChaincodeStubInterface, name string) (int64, error) {
+	value, keys, err := scanCounter(stub, name)
+	if err != nil {
+		return 0, err
+	}
+
+	baseKey, err := stub.CreateCompositeKey(CounterPrefix, []string{name, ""})
+	if err != nil {
+		return 0, fmt.Errorf("CompactCounter failed because stub.CreateCompositeKey failed with error %v", err)
+	}
+	for _, key := range keys {
+		if key == baseKey {
+			continue
+		}
+		if err = stub.DelState(key); err != nil {
+			return 0, fmt.Errorf("CompactCounter failed because stub.DelState(%v) failed with error %v", key, err)
+		}
+	}
+	if err = stub.PutState(baseKey, []byte(strconv.FormatInt(value, 10))); err != nil {
+		return 0, fmt.Errorf("CompactCounter failed because stub.PutState(%v) failed with error %v", baseKey, err)
+	}
+	return value, nil
+}
+
+// scanCounter sums all the keys of a counter and returns them.
+func scanCounter(stub shim.ChaincodeStubInterface, name string) (value int64, keys []string, err error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(CounterPrefix, []string{name})
+	if err != nil {
+		return 0, nil, fmt.Errorf("scanCounter failed because stub.GetStateByPartialCompositeKey failed with error %v", err)
+	}
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return 0, nil, fmt.Errorf("scanCounter failed because iterator.Next failed with error %v", err)
+		}
+		delta, err := strconv.ParseInt(string(kv.Value), 10, 64)
+		if err != nil {
+			return 0, nil, fmt.Errorf("scanCounter failed because key %q does not hold a counter delta: %v", kv.Key, err)
+		}
+		value += delta
+		keys = append(keys, kv.Key)
+	}
+	return value, keys, nil
+}
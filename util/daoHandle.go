@@ -33,7 +33,8 @@ import (
 )
 
 // ChangeInfo overwrites a value in the state database by performing an insert with
-// overwrite indicator
+// overwrite indicator. If the table schema declares a VersionField, data must carry
+// the version that was read, otherwise a VersionConflictError is returned.
 func ChangeInfo(stub shim.ChaincodeStubInterface, DocPrefix string, rowKey []string, data interface{}) error {
 	_, err := InsertTableRow(stub, DocPrefix, rowKey, data, FAIL_UNLESS_OVERWRITE, nil)
 	return err
@@ -50,8 +51,10 @@ func UpdateExistingData(stub shim.ChaincodeStubInterface, DocPrefix string, rowK
 // CreateData simply inserts a new key-value pair into the state database. It will fail if the key already
 // exists. The pair is formatted as follows.
 //
-// Key: DocPrefix_rowKey[0]_rowKey[1]_..._rowKey[n]_randomId
+// Key: DocPrefix_rowKey[0]_rowKey[1]_..._rowKey[n]
 // Value: JSON document parsed from the data object.
+//
+// Use CreateDataWithID to have an ID generated and appended to the key.
 func CreateData(stub shim.ChaincodeStubInterface, DocPrefix string, rowKey []string, data interface{}) error {
 	var oldData interface{}
 	rowWasFound, err := InsertTableRow(stub, DocPrefix, rowKey, data, FAIL_BEFORE_OVERWRITE, &oldData)
@@ -64,6 +67,23 @@ func CreateData(stub shim.ChaincodeStubInterface, DocPrefix string, rowKey []str
 	return nil //success
 }
 
+// CreateDataWithID works like CreateData but appends an ID produced by generator to the key
+// and returns it. The pair is formatted as follows.
+//
+// Key: DocPrefix_rowKey[0]_rowKey[1]_..._rowKey[n]_generatedId
+// Value: JSON document parsed from the data object.
+func CreateDataWithID(stub shim.ChaincodeStubInterface, DocPrefix string, rowKey []string, data interface{}, generator IDGenerator) (string, error) {
+	id, err := NewID(stub, DocPrefix, generator, data)
+	if err != nil {
+		return "", err
+	}
+	keys := append(append(make([]string, 0, len(rowKey)+1), rowKey...), id)
+	if err = CreateData(stub, DocPrefix, keys, data); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
 // GetDataById get the state value of a key from the state database
 // It returns a generic object.
 func GetDataById(stub shim.ChaincodeStubInterface, ID string, DocPrefix string) (interface{}, error) {
@@ -0,0 +1,120 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Every endorsing peer must produce exactly the same keys, so IDs can never come from
+// random sources or the clock of the peer. The generators below only depend on the
+// transaction and on the ledger.
+
+// This is effectively a strongly typed enum declaration.
+type IDGenerator uint8
+
+const (
+	TX_BASED_ID IDGenerator = 0 // see NewTxBasedID
+	CONTENT_ID  IDGenerator = 1 // see NewContentID
+	SEQUENCE_ID IDGenerator = 2 // see NextSequenceValue
+)
+
+// SequencePrefix is the object type of the keys that hold the last value of each sequence.
+const SequencePrefix = "AKC~SEQUENCE"
+
+// NewTxBasedID returns an ID made of the transaction ID and a counter of the IDs already
+// generated by the invocation, e.g. "<txID>-0", "<txID>-1", ... Every simulation of the
+// transaction generates the same IDs.
+func NewTxBasedID(stub shim.ChaincodeStubInterface) string {
+	return fmt.Sprintf("%s-%d", stub.GetTxID(), nextTxSequence(stub, "AKC~ID"))
+}
+
+// NewContentID returns the hex encoded SHA-256 of the JSON form of data, so identical
+// documents always get the same ID.
+func NewContentID(data interface{}) (string, error) {
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("NewContentID failed because json.Marshal failed with error %v", err)
+	}
+	sum := sha256.Sum256(bytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// NextSequenceValue returns the next value (starting at 1) of a monotonic sequence kept in
+// the ledger, usually one per table. Every transaction that takes a value writes the same
+// key, so concurrent transactions of a block conflict with each other; prefer the other
+// generators for tables with a high insert rate.
+func NextSequenceValue(stub shim.ChaincodeStubInterface, name string) (uint64, error) {
+	scope := SequencePrefix + name
+	sequenceKey, err := stub.CreateCompositeKey(SequencePrefix, []string{name})
+	if err != nil {
+		return 0, fmt.Errorf("NextSequenceValue failed because stub.CreateCompositeKey failed with error %v", err)
+	}
+
+	// The transaction does not see its own writes, so the value read from the ledger the
+	// first time is remembered and the values already taken are counted in memory.
+	taken := nextTxSequence(stub, scope)
+	var last uint64
+	if remembered, found := recallTxValue(stub, scope); found {
+		last = remembered.(uint64)
+	} else {
+		bytes, err := stub.GetState(sequenceKey)
+		if err != nil {
+			return 0, fmt.Errorf("NextSequenceValue failed because stub.GetState(%v) failed with error %v", sequenceKey, err)
+		}
+		if bytes != nil {
+			if last, err = strconv.ParseUint(string(bytes), 10, 64); err != nil {
+				return 0, fmt.Errorf("NextSequenceValue failed because sequence %s holds %q: %v", name, bytes, err)
+			}
+		}
+		rememberTxValue(stub, scope, last)
+	}
+
+	next := last + 1 + taken
+	if err = stub.PutState(sequenceKey, []byte(strconv.FormatUint(next, 10))); err != nil {
+		return 0, fmt.Errorf("NextSequenceValue failed because stub.PutState(%v) failed with error %v", sequenceKey, err)
+	}
+	return next, nil
+}
+
+// NewID generates an ID for a row of table_name with the given generator. Sequence values
+// are encoded with EncodeUintKey so that they sort in numeric order within composite keys.
+func NewID(stub shim.ChaincodeStubInterface, table_name string, generator IDGenerator, data interface{}) (string, error) {
+	switch generator {
+	case TX_BASED_ID:
+		return NewTxBasedID(stub), nil
+	case CONTENT_ID:
+		return NewContentID(data)
+	case SEQUENCE_ID:
+		value, err := NextSequenceValue(stub, table_name)
+		if err != nil {
+			return "", err
+		}
+		return EncodeUintKey(value), nil
+	default:
+		return "", fmt.Errorf("NewID failed because generator %d is unknown", generator)
+	}
+}
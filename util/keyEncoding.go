@@ -0,0 +1,288 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// The state database orders keys byte by byte, so "10" comes before "9" and timestamps in
+// arbitrary formats do not sort by time. The encoders below turn values into key components
+// whose lexical order is the order of the values. They are meant to be used in the row_keys
+// of util tables together with GetTableRowsByRange. Every encoding is printable ASCII.
+
+// timeKeyLayout is RFC3339 in UTC with a fixed number of fractional digits.
+const timeKeyLayout = "2006-01-02T15:04:05.000000000Z"
+
+// decimalExponentBias keeps the exponent of encoded decimals in 4 non negative digits.
+const decimalExponentBias = 5000
+
+// EncodeUintKey encodes an unsigned integer as 20 zero padded digits.
+func EncodeUintKey(value uint64) string {
+	return fmt.Sprintf("%020d", value)
+}
+
+// DecodeUintKey decodes a component produced by EncodeUintKey.
+func DecodeUintKey(component string) (uint64, error) {
+	value, err := strconv.ParseUint(component, 10, 64)
+	if err != nil || len(component) != 20 {
+		return 0, fmt.Errorf("DecodeUintKey failed because %q is not an encoded unsigned integer", component)
+	}
+	return value, nil
+}
+
+// EncodeIntKey encodes a signed integer so that negative values sort before positive ones.
+// The sign bit is flipped and the result written as 20 zero padded digits.
+func EncodeIntKey(value int64) string {
+	return EncodeUintKey(uint64(value) ^ (1 << 63))
+}
+
+// DecodeIntKey decodes a component produced by EncodeIntKey.
+func DecodeIntKey(component string) (int64, error) {
+	value, err := DecodeUintKey(component)
+	if err != nil {
+		return 0, fmt.Errorf("DecodeIntKey failed because %q is not an encoded integer", component)
+	}
+	return int64(value ^ (1 << 63)), nil
+}
+
+// EncodeTimeKey encodes a time as RFC3339 in UTC with nanoseconds, which sorts by time for
+// the years 0000 to 9999.
+func EncodeTimeKey(t time.Time) string {
+	return t.UTC().Format(timeKeyLayout)
+}
+
+// EncodeRFC3339Key parses an RFC3339 timestamp in any time zone and encodes it with EncodeTimeKey.
+func EncodeRFC3339Key(timestamp string) (string, error) {
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return "", fmt.Errorf("EncodeRFC3339Key failed because time.Parse failed with error %v", err)
+	}
+	return EncodeTimeKey(t), nil
+}
+
+// DecodeTimeKey decodes a component produced by EncodeTimeKey.
+func DecodeTimeKey(component string) (time.Time, error) {
+	t, err := time.Parse(timeKeyLayout, component)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("DecodeTimeKey failed because time.Parse failed with error %v", err)
+	}
+	return t, nil
+}
+
+// EncodeDecimalKey encodes a decimal number written in plain notation, such as "-12.50",
+// without losing precision. The number is normalised to 0.d1d2...dn x 10^e and encoded as
+//
+//	"2" + (e + 5000 on 4 digits) + d1...dn            for positive numbers
+//	"1"                                               for zero
+//	"0" + (4999 - e on 4 digits) + (9-d1)...(9-dn) + "~" for negative numbers
+//
+// so that more digits only sort higher for positive numbers and lower for negative ones.
+func EncodeDecimalKey(decimal string) (string, error) {
+	negative, exponent, digits, err := parseDecimal(decimal)
+	if err != nil {
+		return "", err
+	}
+	if digits == "" {
+		return "1", nil
+	}
+	if exponent <= -decimalExponentBias || exponent >= decimalExponentBias {
+		return "", fmt.Errorf("EncodeDecimalKey failed because %q is out of range", decimal)
+	}
+	if !negative {
+		return fmt.Sprintf("2%04d%s", exponent+decimalExponentBias, digits), nil
+	}
+	complement := make([]byte, len(digits))
+	for i := 0; i < len(digits); i++ {
+		complement[i] = '9' - digits[i] + '0'
+	}
+	return fmt.Sprintf("0%04d%s~", decimalExponentBias-1-exponent, complement), nil
+}
+
+// DecodeDecimalKey decodes a component produced by EncodeDecimalKey into a normalised
+// decimal string, e.g. "-12.5".
+func DecodeDecimalKey(component string) (string, error) {
+	invalid := fmt.Errorf("DecodeDecimalKey failed because %q is not an encoded decimal", component)
+	if component == "1" {
+		return "0", nil
+	}
+	if len(component) < 6 {
+		return "", invalid
+	}
+	biased, err := strconv.Atoi(component[1:5])
+	if err != nil {
+		return "", invalid
+	}
+
+	var negative bool
+	var exponent int
+	var digits string
+	switch component[0] {
+	case '2':
+		exponent, digits = biased-decimalExponentBias, component[5:]
+	case '0':
+		if !strings.HasSuffix(component, "~") {
+			return "", invalid
+		}
+		negative, exponent = true, decimalExponentBias-1-biased
+		complement := []byte(component[5 : len(component)-1])
+		for i := range complement {
+			complement[i] = '9' - complement[i] + '0'
+		}
+		digits = string(complement)
+	default:
+		return "", invalid
+	}
+	for _, d := range digits {
+		if d < '0' || d > '9' {
+			return "", invalid
+		}
+	}
+
+	var plain string
+	switch {
+	case exponent <= 0:
+		plain = "0." + strings.Repeat("0", -exponent) + digits
+	case exponent >= len(digits):
+		plain = digits + strings.Repeat("0", exponent-len(digits))
+	default:
+		plain = digits[:exponent] + "." + digits[exponent:]
+	}
+	if negative {
+		plain = "-" + plain
+	}
+	return plain, nil
+}
+
+// parseDecimal splits a plain decimal into its sign, exponent and significant digits
+// without leading or trailing zeros. Zero has no digits.
+func parseDecimal(decimal string) (negative bool, exponent int, digits string, err error) {
+	s := decimal
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
+	intPart, fracPart := s, ""
+	if dot := strings.IndexByte(s, '.'); dot >= 0 {
+		intPart, fracPart = s[:dot], s[dot+1:]
+	}
+	if intPart == "" && fracPart == "" {
+		return false, 0, "", fmt.Errorf("parseDecimal failed because %q is not a decimal", decimal)
+	}
+	for _, d := range intPart + fracPart {
+		if d < '0' || d > '9' {
+			return false, 0, "", fmt.Errorf("parseDecimal failed because %q is not a decimal", decimal)
+		}
+	}
+
+	all := intPart + fracPart
+	exponent = len(intPart)
+	trimmed := strings.TrimLeft(all, "0")
+	exponent -= len(all) - len(trimmed)
+	digits = strings.TrimRight(trimmed, "0")
+	if digits == "" {
+		return false, 0, "", nil
+	}
+	return negative, exponent, digits, nil
+}
+
+// EncodeDescendingKey turns an encoded component into one that sorts in the opposite order,
+// e.g. to scan the most recent rows first. Every byte c becomes 0x9E - c, which maps printable
+// ASCII onto itself in reverse, and 0x7F is appended so that a longer component still sorts
+// before its prefixes.
+func EncodeDescendingKey(component string) (string, error) {
+	reversed := make([]byte, len(component)+1)
+	for i := 0; i < len(component); i++ {
+		c := component[i]
+		if c < 0x20 || c > 0x7E {
+			return "", fmt.Errorf("EncodeDescendingKey failed because %q is not printable ASCII", component)
+		}
+		reversed[i] = 0x9E - c
+	}
+	reversed[len(component)] = 0x7F
+	return string(reversed), nil
+}
+
+// DecodeDescendingKey decodes a component produced by EncodeDescendingKey.
+func DecodeDescendingKey(component string) (string, error) {
+	if !strings.HasSuffix(component, "\x7f") {
+		return "", fmt.Errorf("DecodeDescendingKey failed because %q is not a descending component", component)
+	}
+	original := []byte(component[:len(component)-1])
+	for i, c := range original {
+		original[i] = 0x9E - c
+	}
+	return string(original), nil
+}
+
+// GetTableRowsByRange returns the rows of table_name whose keys start with row_keys and whose
+// next key component lies in [start_component, end_component). An empty bound is unbounded.
+// The components must be encoded so that their lexical order is the wanted order.
+//
+// Fabric does not accept composite keys in GetStateByRange, so the rows are read with a
+// partial composite key query on row_keys: the scan stops at end_component but still walks
+// over the rows before start_component. Put the range component right after a selective prefix.
+func GetTableRowsByRange(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	row_keys []string,
+	start_component string,
+	end_component string,
+) (chan []byte, error) {
+	state_query_iterator, err := stub.GetStateByPartialCompositeKey(table_name, row_keys)
+	if err != nil {
+		return nil, fmt.Errorf("GetTableRowsByRange failed because stub.GetStateByPartialCompositeKey failed with error %v", err)
+	}
+
+	rowJSONBytesChannel := make(chan []byte, 32)
+
+	go func() {
+		defer close(rowJSONBytesChannel)
+		defer state_query_iterator.Close()
+		for state_query_iterator.HasNext() {
+			query_result_kv, err := state_query_iterator.Next()
+			if err != nil {
+				panic("this should never happen probably")
+			}
+			_, keys, err := stub.SplitCompositeKey(query_result_kv.Key)
+			if err != nil || len(keys) <= len(row_keys) {
+				continue
+			}
+			component := keys[len(row_keys)]
+			if component < start_component {
+				continue
+			}
+			if end_component != "" && component >= end_component {
+				break
+			}
+			if isTombstoned(table_name, query_result_kv.Value) {
+				continue
+			}
+			rowJSONBytesChannel <- query_result_kv.Value
+		}
+	}()
+
+	return rowJSONBytesChannel, nil
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// RowHistoryEntry is a committed modification of a table row. Value is nil when the row was
+// deleted; a soft deleted row keeps its value and has IsSoftDeleted set.
+type RowHistoryEntry struct {
+	TxID          string          `json:"TxID"`
+	Timestamp     time.Time       `json:"Timestamp"`
+	IsDelete      bool            `json:"IsDelete"`
+	IsSoftDeleted bool            `json:"IsSoftDeleted,omitempty"`
+	Value         json.RawMessage `json:"Value,omitempty"`
+}
+
+// DecodeValue unmarshals the value of the row into row_value. It returns false for deletes.
+func (entry RowHistoryEntry) DecodeValue(row_value interface{}) (bool, error) {
+	if entry.Value == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(entry.Value, row_value); err != nil {
+		return false, fmt.Errorf("DecodeValue failed because json.Unmarshal failed with error %v", err)
+	}
+	return true, nil
+}
+
+// GetTableRowHistory returns a page of the history of a row, from the most recent modification
+// to the oldest, like GetHistoryForKey. Pass an empty bookmark for the first page and the
+// returned bookmark for the next ones; the bookmark is empty after the last page. A page_size
+// of 0 or less returns the whole history.
+//
+// The bookmark is the ID of the last transaction returned, so pages stay consistent when the
+// row is modified between two calls. The history is only available on peers that enable the
+// history database, and GetHistoryForKey must not be used in transactions that write.
+func GetTableRowHistory(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	row_keys []string,
+	page_size int32,
+	bookmark string,
+) (entries []RowHistoryEntry, next_bookmark string, err error) {
+	composite_key, err := stub.CreateCompositeKey(table_name, row_keys)
+	if err != nil {
+		return nil, "", fmt.Errorf("GetTableRowHistory failed because stub.CreateCompositeKey failed with error %v", err)
+	}
+	history, err := readRowHistory(stub, table_name, composite_key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	start := 0
+	if bookmark != "" {
+		for start < len(history) && history[start].TxID != bookmark {
+			start++
+		}
+		if start == len(history) {
+			return nil, "", fmt.Errorf("GetTableRowHistory failed because bookmark %s is not in the history of the row", bookmark)
+		}
+		start++
+	}
+	entries = history[start:]
+	if page_size > 0 && int32(len(entries)) > page_size {
+		entries = entries[:page_size]
+		return entries, entries[len(entries)-1].TxID, nil
+	}
+	return entries, "", nil
+}
+
+// readRowHistory reads the whole history of a row, from the most recent modification to the oldest.
+func readRowHistory(stub shim.ChaincodeStubInterface, table_name string, composite_key string) ([]RowHistoryEntry, error) {
+	iterator, err := stub.GetHistoryForKey(composite_key)
+	if err != nil {
+		return nil, fmt.Errorf("readRowHistory failed because stub.GetHistoryForKey failed with error %v", err)
+	}
+	defer iterator.Close()
+
+	history := make([]RowHistoryEntry, 0)
+	for iterator.HasNext() {
+		modification, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("readRowHistory failed because iterator.Next failed with error %v", err)
+		}
+		entry := RowHistoryEntry{TxID: modification.TxId, IsDelete: modification.IsDelete}
+		if ts := modification.Timestamp; ts != nil {
+			entry.Timestamp = time.Unix(ts.GetSeconds(), int64(ts.GetNanos())).UTC()
+		}
+		if !modification.IsDelete {
+			entry.Value = json.RawMessage(modification.Value)
+			entry.IsSoftDeleted = isTombstoned(table_name, modification.Value)
+		}
+		history = append(history, entry)
+	}
+	return history, nil
+}
@@ -0,0 +1,462 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// This is effectively a strongly typed enum declaration.
+type PatchKeyField_Option bool
+
+const (
+	ALLOW_KEY_FIELD_CHANGES  PatchKeyField_Option = false
+	REJECT_KEY_FIELD_CHANGES PatchKeyField_Option = true // the KeyFields of the table schema must keep their values
+)
+
+// JSONPatchOperation is one operation of an RFC 6902 JSON Patch.
+type JSONPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// MergePatchTableRow applies an RFC 7396 JSON Merge Patch to an existing row and stores the
+// result, all within the current transaction. The row must exist and must not be soft deleted.
+// The patched row goes through the table schema exactly like a row passed to UpdateTableRow.
+// If new_row_value is not nil, the patched row is unmarshaled into it and checked against its
+// validation tags before being stored. The patched row is returned as stored.
+func MergePatchTableRow(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	row_keys []string,
+	merge_patch []byte,
+	key_option PatchKeyField_Option,
+	new_row_value interface{},
+) (row_bytes []byte, err error) {
+	return patchTableRow(stub, table_name, row_keys, key_option, new_row_value, func(document []byte) ([]byte, error) {
+		return ApplyMergePatch(document, merge_patch)
+	})
+}
+
+// JSONPatchTableRow applies an RFC 6902 JSON Patch to an existing row and stores the result.
+// The operations are applied in order and the row is left untouched if any of them fails,
+// including a failing "test" operation. It otherwise behaves like MergePatchTableRow.
+func JSONPatchTableRow(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	row_keys []string,
+	json_patch []byte,
+	key_option PatchKeyField_Option,
+	new_row_value interface{},
+) (row_bytes []byte, err error) {
+	return patchTableRow(stub, table_name, row_keys, key_option, new_row_value, func(document []byte) ([]byte, error) {
+		return ApplyJSONPatch(document, json_patch)
+	})
+}
+
+// patchTableRow reads a row, transforms it with patch, checks the result and stores it.
+func patchTableRow(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	row_keys []string,
+	key_option PatchKeyField_Option,
+	new_row_value interface{},
+	patch func(document []byte) ([]byte, error),
+) ([]byte, error) {
+	composite_key, old_bytes, _, err := getTableRowAndCompositeKey(stub, table_name, row_keys, nil, FAIL_IF_MISSING, EXCLUDE_DELETED)
+	if err != nil {
+		return nil, fmt.Errorf("patchTableRow failed because getTableRowAndCompositeKey failed with error %v", err)
+	}
+
+	new_bytes, err := patch(old_bytes)
+	if err != nil {
+		return nil, fmt.Errorf("patchTableRow failed to patch the row with keys %v: %w", row_keys, err)
+	}
+	oldRow, err := decodeRow(old_bytes)
+	if err != nil {
+		return nil, err
+	}
+	newRow, err := decodeRow(new_bytes)
+	if err != nil || newRow == nil {
+		return nil, fmt.Errorf("patchTableRow failed because the patched row is not a JSON object")
+	}
+
+	// A patch is an update: deleting and restoring rows have their own functions
+	if isTombstoned(table_name, new_bytes) {
+		return nil, fmt.Errorf("patchTableRow failed because the patch sets %s; use DeleteTableRow instead", TombstoneField)
+	}
+	if key_option == REJECT_KEY_FIELD_CHANGES {
+		schema, _ := GetTableSchema(table_name)
+		if len(schema.KeyFields) == 0 {
+			return nil, fmt.Errorf("patchTableRow failed because REJECT_KEY_FIELD_CHANGES was specified but table %s declares no KeyFields", table_name)
+		}
+		for _, field := range schema.KeyFields {
+			oldValue, oldFound := oldRow[field]
+			newValue, newFound := newRow[field]
+			if oldFound != newFound || !jsonValuesEqual(oldValue, newValue) {
+				return nil, fmt.Errorf("patchTableRow failed because the patch changes key field %s and REJECT_KEY_FIELD_CHANGES was specified", field)
+			}
+		}
+	}
+
+	// The validation tags of new_row_value also apply to the patched row
+	if !InterfaceIsNilOrIsZeroOfUnderlyingType(new_row_value) {
+		if err = json.Unmarshal(new_bytes, new_row_value); err != nil {
+			return nil, fmt.Errorf("patchTableRow failed because json.Unmarshal failed with error %v", err)
+		}
+		if err = ValidateStruct(new_row_value); err != nil {
+			return nil, fmt.Errorf("patchTableRow failed because %w", err)
+		}
+	}
+
+	stored_bytes, err := applyTableSchema(stub, table_name, composite_key, old_bytes, new_bytes)
+	if err != nil {
+		return nil, fmt.Errorf("patchTableRow failed because applyTableSchema failed with error %w", err)
+	}
+	err = stub.PutState(composite_key, stored_bytes)
+	if err != nil {
+		return nil, fmt.Errorf("patchTableRow failed because stub.PutState(%v) failed with error %v", composite_key, err)
+	}
+	if !bytes.Equal(stored_bytes, new_bytes) && !InterfaceIsNilOrIsZeroOfUnderlyingType(new_row_value) {
+		// The table schema changed the row, e.g. its version
+		if err = json.Unmarshal(stored_bytes, new_row_value); err != nil {
+			return nil, fmt.Errorf("patchTableRow failed because json.Unmarshal failed with error %v", err)
+		}
+	}
+	return stored_bytes, nil
+}
+
+// ApplyMergePatch applies an RFC 7396 JSON Merge Patch to a JSON document: members of the
+// patch replace those of the document, objects are merged recursively and null removes a member.
+func ApplyMergePatch(document []byte, merge_patch []byte) ([]byte, error) {
+	target, err := decodeJSONValue(document)
+	if err != nil {
+		return nil, fmt.Errorf("ApplyMergePatch failed because the document is not valid JSON: %v", err)
+	}
+	patch, err := decodeJSONValue(merge_patch)
+	if err != nil {
+		return nil, fmt.Errorf("ApplyMergePatch failed because the patch is not valid JSON: %v", err)
+	}
+	patched, err := json.Marshal(mergeJSONValues(target, patch))
+	if err != nil {
+		return nil, fmt.Errorf("ApplyMergePatch failed because json.Marshal failed with error %v", err)
+	}
+	return patched, nil
+}
+
+func mergeJSONValues(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergeJSONValues(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
+
+// ApplyJSONPatch applies the operations of an RFC 6902 JSON Patch (add, remove, replace,
+// move, copy and test) to a JSON document. It fails on the first operation that cannot be
+// applied, reporting its index.
+func ApplyJSONPatch(document []byte, json_patch []byte) ([]byte, error) {
+	target, err := decodeJSONValue(document)
+	if err != nil {
+		return nil, fmt.Errorf("ApplyJSONPatch failed because the document is not valid JSON: %v", err)
+	}
+	var operations []JSONPatchOperation
+	if err = json.Unmarshal(json_patch, &operations); err != nil {
+		return nil, fmt.Errorf("ApplyJSONPatch failed because json.Unmarshal failed with error %v", err)
+	}
+
+	for i, operation := range operations {
+		target, err = applyJSONPatchOperation(target, operation)
+		if err != nil {
+			return nil, fmt.Errorf("ApplyJSONPatch failed at operation %d (%s %s): %v", i, operation.Op, operation.Path, err)
+		}
+	}
+	patched, err := json.Marshal(target)
+	if err != nil {
+		return nil, fmt.Errorf("ApplyJSONPatch failed because json.Marshal failed with error %v", err)
+	}
+	return patched, nil
+}
+
+func applyJSONPatchOperation(document interface{}, operation JSONPatchOperation) (interface{}, error) {
+	path, err := parseJSONPointer(operation.Path)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	switch operation.Op {
+	case "add", "replace", "test":
+		if operation.Value == nil {
+			return nil, fmt.Errorf("the operation has no value")
+		}
+		if value, err = decodeJSONValue(operation.Value); err != nil {
+			return nil, fmt.Errorf("the value is not valid JSON: %v", err)
+		}
+	case "move", "copy":
+		from, err := parseJSONPointer(operation.From)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = getJSONPointer(document, from); err != nil {
+			return nil, err
+		}
+		if operation.Op == "copy" {
+			// The copy must not share maps or slices with the original
+			raw, _ := json.Marshal(value)
+			value, _ = decodeJSONValue(raw)
+			break
+		}
+		if strings.HasPrefix(operation.Path, operation.From+"/") {
+			return nil, fmt.Errorf("a value cannot be moved into one of its children")
+		}
+		if document, err = removeJSONPointer(document, from); err != nil {
+			return nil, err
+		}
+	}
+
+	switch operation.Op {
+	case "add", "move", "copy":
+		return addJSONPointer(document, path, value)
+	case "remove":
+		return removeJSONPointer(document, path)
+	case "replace":
+		if len(path) == 0 {
+			return value, nil
+		}
+		if document, err = removeJSONPointer(document, path); err != nil {
+			return nil, err
+		}
+		return addJSONPointer(document, path, value)
+	case "test":
+		actual, err := getJSONPointer(document, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonValuesEqual(actual, value) {
+			return nil, fmt.Errorf("test failed because the value is %s", jsonString(actual))
+		}
+		return document, nil
+	default:
+		return nil, fmt.Errorf("unknown operation %q", operation.Op)
+	}
+}
+
+// parseJSONPointer splits an RFC 6901 JSON Pointer into its unescaped reference tokens.
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%q is not a JSON pointer", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func getJSONPointer(document interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := document.(type) {
+		case map[string]interface{}:
+			child, found := node[token]
+			if !found {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			document = child
+		case []interface{}:
+			index, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			document = node[index]
+		default:
+			return nil, fmt.Errorf("%q cannot be looked up in a scalar value", token)
+		}
+	}
+	return document, nil
+}
+
+// addJSONPointer adds value at path and returns the new document. The parent of path must exist.
+func addJSONPointer(document interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token := path[0]
+	switch node := document.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			node[token] = value
+			return node, nil
+		}
+		child, found := node[token]
+		if !found {
+			return nil, fmt.Errorf("member %q does not exist", token)
+		}
+		child, err := addJSONPointer(child, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		node[token] = child
+		return node, nil
+	case []interface{}:
+		if len(path) == 1 {
+			index := len(node)
+			if token != "-" {
+				var err error
+				if index, err = arrayIndex(token, len(node)); err != nil {
+					return nil, err
+				}
+			}
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+			return node, nil
+		}
+		index, err := arrayIndex(token, len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+		if node[index], err = addJSONPointer(node[index], path[1:], value); err != nil {
+			return nil, err
+		}
+		return node, nil
+	default:
+		return nil, fmt.Errorf("%q cannot be added to a scalar value", token)
+	}
+}
+
+// removeJSONPointer removes the value at path, which must exist, and returns the new document.
+func removeJSONPointer(document interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("the whole document cannot be removed")
+	}
+	token := path[0]
+	switch node := document.(type) {
+	case map[string]interface{}:
+		child, found := node[token]
+		if !found {
+			return nil, fmt.Errorf("member %q does not exist", token)
+		}
+		if len(path) == 1 {
+			delete(node, token)
+			return node, nil
+		}
+		child, err := removeJSONPointer(child, path[1:])
+		if err != nil {
+			return nil, err
+		}
+		node[token] = child
+		return node, nil
+	case []interface{}:
+		index, err := arrayIndex(token, len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 1 {
+			return append(node[:index], node[index+1:]...), nil
+		}
+		if node[index], err = removeJSONPointer(node[index], path[1:]); err != nil {
+			return nil, err
+		}
+		return node, nil
+	default:
+		return nil, fmt.Errorf("%q cannot be removed from a scalar value", token)
+	}
+}
+
+// arrayIndex parses an array index token that must lie in [0, max].
+func arrayIndex(token string, max int) (int, error) {
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || index > max || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%q is not a valid array index", token)
+	}
+	return index, nil
+}
+
+// decodeJSONValue parses any JSON value, keeping numbers as json.Number.
+func decodeJSONValue(raw []byte) (interface{}, error) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// jsonValuesEqual compares two decoded JSON values, numbers being compared by value.
+func jsonValuesEqual(a interface{}, b interface{}) bool {
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for name, value := range x {
+			other, found := y[name]
+			if !found || !jsonValuesEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonValuesEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		order, comparable := compareJSONValues(x, b)
+		return comparable && order == 0
+	default:
+		return a == b
+	}
+}
+
+func jsonString(value interface{}) string {
+	bytes, _ := json.Marshal(value)
+	return string(bytes)
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// TombstoneField is the JSON field that marks a row of a SoftDelete table as deleted.
+const TombstoneField = "AkcTombstone"
+
+// Tombstone records who deleted a row and when.
+type Tombstone struct {
+	TxID      string    `json:"TxID"`
+	Timestamp time.Time `json:"Timestamp"`
+	Creator   TxActor   `json:"Creator"`
+}
+
+// GetTableRowTombstone returns the tombstone of a soft deleted row, or nil if the row
+// is missing or has not been deleted.
+func GetTableRowTombstone(stub shim.ChaincodeStubInterface, table_name string, row_keys []string) (*Tombstone, error) {
+	var row struct {
+		Tombstone *Tombstone `json:"AkcTombstone"`
+	}
+	_, err := GetTableRowIncludeDeleted(stub, table_name, row_keys, &row, DONT_FAIL_IF_MISSING)
+	if err != nil {
+		return nil, err
+	}
+	return row.Tombstone, nil
+}
+
+// RestoreTableRow brings a soft deleted row back. The unique values of the row are
+// reserved again, so the restore fails if another row has taken them in the meantime.
+// Business rules are not checked: the row is restored as it was stored.
+func RestoreTableRow(stub shim.ChaincodeStubInterface, table_name string, row_keys []string) error {
+	composite_key, old_bytes, _, err := getTableRowAndCompositeKey(stub, table_name, row_keys, nil, FAIL_IF_MISSING, INCLUDE_DELETED)
+	if err != nil {
+		return fmt.Errorf("RestoreTableRow failed because getTableRowAndCompositeKey failed with error %v", err)
+	}
+	if !isTombstoned(table_name, old_bytes) {
+		return fmt.Errorf("RestoreTableRow failed because the row with keys %v is not deleted", row_keys)
+	}
+
+	row, err := decodeRow(old_bytes)
+	if err != nil {
+		return err
+	}
+	delete(row, TombstoneField)
+	bytes, err := json.Marshal(row)
+	if err != nil {
+		return fmt.Errorf("RestoreTableRow failed because json.Marshal failed with error %v", err)
+	}
+
+	bytes, err = applyTableSchema(stub, table_name, composite_key, old_bytes, bytes)
+	if err != nil {
+		return fmt.Errorf("RestoreTableRow failed because applyTableSchema failed with error %w", err)
+	}
+	if err = stub.PutState(composite_key, bytes); err != nil {
+		return fmt.Errorf("RestoreTableRow failed because stub.PutState(%v) failed with error %v", composite_key, err)
+	}
+	return nil
+}
+
+// PurgeTableRow removes a row from the state for good, whether it has been soft deleted or not.
+// If old_row_value is not nil, then the table row will be unmarshaled into old_row_value before being purged.
+func PurgeTableRow(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	row_keys []string,
+	old_row_value interface{},
+	failure_option GetTableRow_FailureOption,
+) (rowWasFound bool, err error) {
+	composite_key, old_bytes, rowWasFound, err := getTableRowAndCompositeKey(stub, table_name, row_keys, old_row_value, failure_option, INCLUDE_DELETED)
+	if err != nil {
+		return rowWasFound, fmt.Errorf("PurgeTableRow failed because getTableRowAndCompositeKey failed with error %v", err)
+	}
+	if !rowWasFound {
+		return false, nil
+	}
+
+	if _, err = applyTableSchema(stub, table_name, composite_key, old_bytes, nil); err != nil {
+		return rowWasFound, fmt.Errorf("PurgeTableRow failed because applyTableSchema failed with error %w", err)
+	}
+	if err = stub.DelState(composite_key); err != nil {
+		return rowWasFound, fmt.Errorf("PurgeTableRow failed because stub.DelState(%v) failed with error %v", composite_key, err)
+	}
+	return rowWasFound, nil
+}
+
+// softDeleteRow stores old_bytes back with a tombstone of the current transaction.
+func softDeleteRow(stub shim.ChaincodeStubInterface, table_name string, composite_key string, old_bytes []byte) error {
+	txTime, err := GetTxTime(stub)
+	if err != nil {
+		return err
+	}
+	actor, err := GetTxActor(stub)
+	if err != nil {
+		return err
+	}
+
+	row, err := decodeRow(old_bytes)
+	if err != nil {
+		return err
+	}
+	row[TombstoneField] = &Tombstone{TxID: stub.GetTxID(), Timestamp: txTime, Creator: actor}
+	bytes, err := json.Marshal(row)
+	if err != nil {
+		return fmt.Errorf("softDeleteRow failed because json.Marshal failed with error %v", err)
+	}
+
+	bytes, err = applyTableSchema(stub, table_name, composite_key, old_bytes, bytes)
+	if err != nil {
+		return err
+	}
+	if err = stub.PutState(composite_key, bytes); err != nil {
+		return fmt.Errorf("softDeleteRow failed because stub.PutState(%v) failed with error %v", composite_key, err)
+	}
+	return nil
+}
+
+// isTombstoned reports whether row_bytes is a soft deleted row of a SoftDelete table.
+func isTombstoned(table_name string, row_bytes []byte) bool {
+	schema, found := GetTableSchema(table_name)
+	if !found || !schema.SoftDelete || row_bytes == nil {
+		return false
+	}
+	var row map[string]json.RawMessage
+	if err := json.Unmarshal(row_bytes, &row); err != nil {
+		return false
+	}
+	_, deleted := row[TombstoneField]
+	return deleted
+}
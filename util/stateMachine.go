@@ -0,0 +1,348 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Akachain/akc-go-sdk-v2/common"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// TransitionPrefix is the object type of the keys that hold the transition history of rows.
+const TransitionPrefix = "AKC~TRANSITION"
+
+// DefaultTransitionEvent is the name of the chaincode event emitted by StateMachine.Apply
+// when the state machine does not name one.
+const DefaultTransitionEvent = "StateTransition"
+
+// StateMachine describes the lifecycle of the rows of a util table whose state is kept in
+// StateField, e.g. the "Status" of orders. It is meant to be declared once, as a package
+// level variable of the chaincode.
+type StateMachine struct {
+	Table       string
+	StateField  string
+	States      []string
+	Transitions []Transition
+	EventName   string // name of the chaincode event, DefaultTransitionEvent if empty
+}
+
+// Transition moves a row from any of the From states to the To state. Guards are run in
+// order before the row is changed and the first failing guard rejects the transition.
+// Effects are then run in order; they may change ctx.Row and write other state.
+type Transition struct {
+	Name    string
+	From    []string
+	To      string
+	Guards  []TransitionGuard
+	Effects []TransitionEffect
+}
+
+// TransitionGuard decides whether a transition may happen.
+type TransitionGuard func(ctx *TransitionContext) error
+
+// TransitionEffect is a side effect of a transition.
+type TransitionEffect func(ctx *TransitionContext) error
+
+// TransitionContext is given to the guards and effects of a transition.
+type TransitionContext struct {
+	Stub       shim.ChaincodeStubInterface
+	Table      string
+	RowKeys    []string
+	Transition string
+	From       string
+	To         string
+	Row        map[string]interface{} // the row being changed, numbers are json.Number
+	Actor      TxActor
+	Input      interface{} // passed through from StateMachine.Apply
+}
+
+// TransitionRecord is an entry of the transition history of a row. It is also the payload
+// of the chaincode event emitted by StateMachine.Apply.
+type TransitionRecord struct {
+	Table      string    `json:"Table"`
+	RowKeys    []string  `json:"RowKeys"`
+	Transition string    `json:"Transition"`
+	From       string    `json:"From"`
+	To         string    `json:"To"`
+	TxID       string    `json:"TxID"`
+	Timestamp  time.Time `json:"Timestamp"`
+	Creator    TxActor   `json:"Creator"`
+}
+
+// TransitionError is returned when a transition is rejected. Code is common.ERR20 for
+// transitions that do not exist or do not start from the current state, and common.ERR21
+// for transitions refused by a guard.
+type TransitionError struct {
+	Code       string
+	Table      string
+	RowKeys    []string
+	Transition string
+	From       string
+	Reason     string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s %s: transition %s of the row of table %s with keys %v from state %q: %s",
+		e.Code, common.ResCodeDict[e.Code], e.Transition, e.Table, e.RowKeys, e.From, e.Reason)
+}
+
+// IsTransitionError reports whether err, or an error it wraps, is a TransitionError.
+func IsTransitionError(err error) bool {
+	var transition *TransitionError
+	return errors.As(err, &transition)
+}
+
+// RequireMSP is a guard that only lets clients of the given MSPs perform the transition.
+func RequireMSP(mspIDs ...string) TransitionGuard {
+	return func(ctx *TransitionContext) error {
+		for _, mspID := range mspIDs {
+			if ctx.Actor.MSPID == mspID {
+				return nil
+			}
+		}
+		return fmt.Errorf("clients of MSP %q are not allowed", ctx.Actor.MSPID)
+	}
+}
+
+// RequireAttribute is a guard that only lets clients whose certificate carries the attribute
+// with the given value perform the transition, e.g. RequireAttribute("role", "approver").
+func RequireAttribute(name string, value string) TransitionGuard {
+	return func(ctx *TransitionContext) error {
+		if err := cid.AssertAttributeValue(ctx.Stub, name, value); err != nil {
+			return fmt.Errorf("the client does not have attribute %s=%s: %v", name, value, err)
+		}
+		return nil
+	}
+}
+
+// Validate checks that every transition goes between declared states. Apply runs it, but
+// calling it when the chaincode starts reveals mistakes earlier.
+func (sm StateMachine) Validate() error {
+	if sm.Table == "" || sm.StateField == "" {
+		return fmt.Errorf("the state machine needs a table and a state field")
+	}
+	declared := make(map[string]bool, len(sm.States))
+	for _, state := range sm.States {
+		declared[state] = true
+	}
+	names := make(map[string]bool, len(sm.Transitions))
+	for _, transition := range sm.Transitions {
+		if names[transition.Name] {
+			return fmt.Errorf("transition %s of table %s is declared twice", transition.Name, sm.Table)
+		}
+		names[transition.Name] = true
+		for _, state := range append([]string{transition.To}, transition.From...) {
+			if !declared[state] {
+				return fmt.Errorf("transition %s of table %s uses undeclared state %q", transition.Name, sm.Table, state)
+			}
+		}
+	}
+	return nil
+}
+
+// Apply performs a transition on the row with row_keys: it checks that the transition starts
+// from the current state, runs the guards, sets the state, runs the effects, stores the row
+// with UpdateTableRow, records the transition in the history of the row and emits a
+// chaincode event carrying the TransitionRecord. If the schema of the table enables Events,
+// the transition is named in the change event of the row instead, which FlushChangeEvents
+// emits. If row_value is not nil, the new row is unmarshaled into it and checked against its
+// validation tags before being stored.
+//
+// A transaction does not read its own writes, so a row must not go through two transitions
+// in the same transaction.
+func (sm StateMachine) Apply(
+	stub shim.ChaincodeStubInterface,
+	row_keys []string,
+	transition_name string,
+	input interface{},
+	row_value interface{},
+) (TransitionRecord, error) {
+	if err := sm.Validate(); err != nil {
+		return TransitionRecord{}, fmt.Errorf("StateMachine.Apply failed because %v", err)
+	}
+	_, row_bytes, _, err := getTableRowAndCompositeKey(stub, sm.Table, row_keys, nil, FAIL_IF_MISSING, EXCLUDE_DELETED)
+	if err != nil {
+		return TransitionRecord{}, fmt.Errorf("StateMachine.Apply failed because getTableRowAndCompositeKey failed with error %v", err)
+	}
+	row, err := decodeRow(row_bytes)
+	if err != nil {
+		return TransitionRecord{}, err
+	}
+	from, _, err := fieldValueString(row, sm.StateField)
+	if err != nil {
+		return TransitionRecord{}, err
+	}
+	rejected := func(code string, reason string) error {
+		return &TransitionError{Code: code, Table: sm.Table, RowKeys: row_keys, Transition: transition_name, From: from, Reason: reason}
+	}
+
+	var transition *Transition
+	for i := range sm.Transitions {
+		if sm.Transitions[i].Name == transition_name {
+			transition = &sm.Transitions[i]
+		}
+	}
+	if transition == nil {
+		return TransitionRecord{}, rejected(common.ERR20, "the transition does not exist")
+	}
+	allowed := false
+	for _, state := range transition.From {
+		allowed = allowed || state == from
+	}
+	if !allowed {
+		return TransitionRecord{}, rejected(common.ERR20, fmt.Sprintf("the transition only starts from %v", transition.From))
+	}
+
+	actor, err := GetTxActor(stub)
+	if err != nil {
+		return TransitionRecord{}, err
+	}
+	ctx := &TransitionContext{
+		Stub:       stub,
+		Table:      sm.Table,
+		RowKeys:    row_keys,
+		Transition: transition.Name,
+		From:       from,
+		To:         transition.To,
+		Row:        row,
+		Actor:      actor,
+		Input:      input,
+	}
+	for _, guard := range transition.Guards {
+		if err = guard(ctx); err != nil {
+			return TransitionRecord{}, rejected(common.ERR21, err.Error())
+		}
+	}
+	row[sm.StateField] = transition.To
+	for _, effect := range transition.Effects {
+		if err = effect(ctx); err != nil {
+			return TransitionRecord{}, fmt.Errorf("StateMachine.Apply failed because an effect of transition %s failed with error %w", transition.Name, err)
+		}
+	}
+	if state, _, _ := fieldValueString(row, sm.StateField); state != transition.To {
+		return TransitionRecord{}, fmt.Errorf("StateMachine.Apply failed because an effect of transition %s changed %s", transition.Name, sm.StateField)
+	}
+
+	new_bytes, err := json.Marshal(row)
+	if err != nil {
+		return TransitionRecord{}, fmt.Errorf("StateMachine.Apply failed because json.Marshal failed with error %v", err)
+	}
+	if !InterfaceIsNilOrIsZeroOfUnderlyingType(row_value) {
+		if err = json.Unmarshal(new_bytes, row_value); err != nil {
+			return TransitionRecord{}, fmt.Errorf("StateMachine.Apply failed because json.Unmarshal failed with error %v", err)
+		}
+		if err = ValidateStruct(row_value); err != nil {
+			return TransitionRecord{}, fmt.Errorf("StateMachine.Apply failed because %w", err)
+		}
+	}
+	if err = UpdateTableRow(stub, sm.Table, row_keys, json.RawMessage(new_bytes)); err != nil {
+		return TransitionRecord{}, fmt.Errorf("StateMachine.Apply failed because UpdateTableRow failed with error %w", err)
+	}
+
+	record, err := sm.recordTransition(stub, ctx)
+	if err != nil {
+		return TransitionRecord{}, err
+	}
+	return record, nil
+}
+
+// recordTransition stores a transition in the history of its row and emits its event.
+func (sm StateMachine) recordTransition(stub shim.ChaincodeStubInterface, ctx *TransitionContext) (TransitionRecord, error) {
+	txTime, err := GetTxTime(stub)
+	if err != nil {
+		return TransitionRecord{}, err
+	}
+	record := TransitionRecord{
+		Table:      sm.Table,
+		RowKeys:    ctx.RowKeys,
+		Transition: ctx.Transition,
+		From:       ctx.From,
+		To:         ctx.To,
+		TxID:       stub.GetTxID(),
+		Timestamp:  txTime,
+		Creator:    ctx.Actor,
+	}
+	recordBytes, err := json.Marshal(&record)
+	if err != nil {
+		return TransitionRecord{}, fmt.Errorf("recordTransition failed because json.Marshal failed with error %v", err)
+	}
+
+	entityKey, _ := json.Marshal(ctx.RowKeys)
+	sequence := strconv.FormatUint(nextTxSequence(stub, TransitionPrefix), 10)
+	historyKey, err := stub.CreateCompositeKey(TransitionPrefix, []string{sm.Table, string(entityKey), EncodeTimeKey(txTime), record.TxID, sequence})
+	if err != nil {
+		return TransitionRecord{}, fmt.Errorf("recordTransition failed because stub.CreateCompositeKey failed with error %v", err)
+	}
+	if err = stub.PutState(historyKey, recordBytes); err != nil {
+		return TransitionRecord{}, fmt.Errorf("recordTransition failed because stub.PutState(%v) failed with error %v", historyKey, err)
+	}
+
+	// On tables that report their changes, the transition joins the change events instead
+	// of replacing them with its own event
+	if schema, _ := GetTableSchema(sm.Table); schema.Events != EVENT_OFF {
+		composite_key, err := stub.CreateCompositeKey(sm.Table, ctx.RowKeys)
+		if err != nil {
+			return TransitionRecord{}, fmt.Errorf("recordTransition failed because stub.CreateCompositeKey failed with error %v", err)
+		}
+		annotateChangeEvent(stub, composite_key, ctx.Transition)
+		return record, nil
+	}
+	eventName := sm.EventName
+	if eventName == "" {
+		eventName = DefaultTransitionEvent
+	}
+	if err = stub.SetEvent(eventName, recordBytes); err != nil {
+		return TransitionRecord{}, fmt.Errorf("recordTransition failed because stub.SetEvent failed with error %v", err)
+	}
+	return record, nil
+}
+
+// GetTransitionHistory returns the transitions of a row in chronological order.
+func GetTransitionHistory(stub shim.ChaincodeStubInterface, table_name string, row_keys []string) ([]TransitionRecord, error) {
+	entityKey, err := json.Marshal(row_keys)
+	if err != nil {
+		return nil, fmt.Errorf("GetTransitionHistory failed because json.Marshal failed with error %v", err)
+	}
+	iterator, err := stub.GetStateByPartialCompositeKey(TransitionPrefix, []string{table_name, string(entityKey)})
+	if err != nil {
+		return nil, fmt.Errorf("GetTransitionHistory failed because stub.GetStateByPartialCompositeKey failed with error %v", err)
+	}
+	defer iterator.Close()
+
+	records := make([]TransitionRecord, 0)
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("GetTransitionHistory failed because iterator.Next failed with error %v", err)
+		}
+		var record TransitionRecord
+		if err = json.Unmarshal(kv.Value, &record); err != nil {
+			return nil, fmt.Errorf("GetTransitionHistory failed because json.Unmarshal failed with error %v", err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
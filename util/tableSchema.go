@@ -0,0 +1,179 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// TableSchema declares the optional behaviours that util applies whenever a row
+// of a table is written through InsertTableRow, UpdateTableRow or DeleteTableRow.
+// Tables without a registered schema behave exactly as plain key-value documents.
+type TableSchema struct {
+	// KeyFields lists the JSON fields of a row that hold its row keys. They are protected
+	// from patches applied with REJECT_KEY_FIELD_CHANGES, see MergePatchTableRow.
+	KeyFields []string
+
+	// UniqueFields lists the JSON fields of a row whose values must be unique across
+	// the whole table. Rows that do not carry the field (or carry null) are not constrained.
+	UniqueFields []string
+
+	// VersionField names the JSON field that util manages as the version of a row.
+	// A new row starts at version 1 and every later write must carry the version the
+	// caller has read, otherwise it fails with a VersionConflictError. The stored
+	// version is incremented on every successful write. Because of this check,
+	// UpdateTableRow has to read the current row of a versioned table.
+	VersionField string
+
+	// SoftDelete makes DeleteTableRow keep the row and mark it with a Tombstone instead of
+	// removing it from the state. Deleted rows are hidden from GetTableRow and GetTableRows,
+	// can be read back with the IncludeDeleted variants, brought back with RestoreTableRow
+	// and removed for good with PurgeTableRow.
+	SoftDelete bool
+
+	// Audit makes every write on the table leave an AuditEntry in the state. See AuditMode.
+	Audit AuditMode
+
+	// Rules makes every insert and update of a row evaluate the business rules stored in the
+	// ledger for the table. See SaveBusinessRule.
+	Rules bool
+
+	// Events makes every write on the table part of the change events of the transaction.
+	// See ChangeEventName and FlushChangeEvents.
+	Events EventMode
+}
+
+var (
+	tableSchemasLock sync.RWMutex
+	tableSchemas     = map[string]TableSchema{}
+)
+
+// RegisterTableSchema attaches a schema to a table. It is meant to be called once
+// when the chaincode starts, e.g. in an init function or in main before shim.Start.
+// Registering a schema for the same table again replaces the previous one.
+func RegisterTableSchema(tableName string, schema TableSchema) {
+	tableSchemasLock.Lock()
+	defer tableSchemasLock.Unlock()
+	tableSchemas[tableName] = schema
+}
+
+// UnregisterTableSchema removes the schema of a table, if any. The rows already stored are
+// left as they are, e.g. with their tombstones or version fields.
+func UnregisterTableSchema(tableName string) {
+	tableSchemasLock.Lock()
+	defer tableSchemasLock.Unlock()
+	delete(tableSchemas, tableName)
+}
+
+// GetTableSchema returns the schema registered for a table, if any.
+func GetTableSchema(tableName string) (schema TableSchema, found bool) {
+	tableSchemasLock.RLock()
+	defer tableSchemasLock.RUnlock()
+	schema, found = tableSchemas[tableName]
+	return
+}
+
+// needsPreviousRow reports whether the schema can only be applied by looking at the
+// row that is about to be replaced. Audit entries and change events tell inserts from
+// updates and list the fields that changed, so they need it too.
+func (schema TableSchema) needsPreviousRow() bool {
+	return len(schema.UniqueFields) > 0 || schema.VersionField != "" || schema.SoftDelete ||
+		schema.Audit != AUDIT_OFF || schema.Events != EVENT_OFF || schema.Rules
+}
+
+// applyTableSchema runs the schema of a table against a row that is about to be written.
+// old_bytes is the row currently stored (nil if there is none) and new_bytes is the row
+// to store, or nil when the row is being deleted. It returns the bytes that must
+// actually be stored.
+func applyTableSchema(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	composite_key string,
+	old_bytes []byte,
+	new_bytes []byte,
+) ([]byte, error) {
+	schema, found := GetTableSchema(table_name)
+	if !found {
+		return new_bytes, nil
+	}
+
+	err := checkBusinessRules(stub, schema, table_name, composite_key, old_bytes, new_bytes)
+	if err != nil {
+		return nil, err
+	}
+	new_bytes, err = stampRowVersion(stub, schema, table_name, composite_key, old_bytes, new_bytes)
+	if err != nil {
+		return nil, err
+	}
+	err = updateUniqueReservations(stub, schema, table_name, composite_key, old_bytes, new_bytes)
+	if err != nil {
+		return nil, err
+	}
+	err = recordAuditEntry(stub, schema, table_name, composite_key, old_bytes, new_bytes)
+	if err != nil {
+		return nil, err
+	}
+	err = recordChangeEvent(stub, schema, table_name, composite_key, old_bytes, new_bytes)
+	if err != nil {
+		return nil, err
+	}
+	return new_bytes, nil
+}
+
+// decodeRow parses a stored JSON document into a generic map. Numbers are kept as
+// json.Number so that re-encoding the document does not alter their representation.
+func decodeRow(rowBytes []byte) (map[string]interface{}, error) {
+	if rowBytes == nil {
+		return nil, nil
+	}
+	var row map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(rowBytes))
+	decoder.UseNumber()
+	if err := decoder.Decode(&row); err != nil {
+		return nil, fmt.Errorf("decodeRow failed because json.Decode failed with error %v", err)
+	}
+	return row, nil
+}
+
+// fieldValueString returns the canonical string form of a top level field of a row and
+// whether the field is present with a non-null value.
+func fieldValueString(row map[string]interface{}, field string) (string, bool, error) {
+	value, ok := row[field]
+	if !ok || value == nil {
+		return "", false, nil
+	}
+	switch v := value.(type) {
+	case string:
+		return v, true, nil
+	case json.Number:
+		return v.String(), true, nil
+	default:
+		bytes, err := json.Marshal(v)
+		if err != nil {
+			return "", false, fmt.Errorf("fieldValueString failed because json.Marshal failed with error %v", err)
+		}
+		return string(bytes), true, nil
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package util
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// TxActor identifies the client that submitted a transaction.
+type TxActor struct {
+	MSPID string `json:"MSPID"`
+	ID    string `json:"ID"`
+}
+
+// GetTxActor returns the MSP ID and the unique ID of the transaction creator.
+// An empty TxActor is returned when the stub carries no creator at all, which only
+// happens with mock stubs that have not been given one.
+func GetTxActor(stub shim.ChaincodeStubInterface) (TxActor, error) {
+	creator, err := stub.GetCreator()
+	if err != nil {
+		return TxActor{}, fmt.Errorf("GetTxActor failed because stub.GetCreator failed with error %v", err)
+	}
+	if len(creator) == 0 {
+		return TxActor{}, nil
+	}
+	identity, err := cid.New(stub)
+	if err != nil {
+		return TxActor{}, fmt.Errorf("GetTxActor failed because cid.New failed with error %v", err)
+	}
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return TxActor{}, fmt.Errorf("GetTxActor failed because GetMSPID failed with error %v", err)
+	}
+	id, err := identity.GetID()
+	if err != nil {
+		return TxActor{}, fmt.Errorf("GetTxActor failed because GetID failed with error %v", err)
+	}
+	return TxActor{MSPID: mspID, ID: id}, nil
+}
+
+// GetTxTime returns the timestamp of the transaction proposal in UTC. Unlike time.Now,
+// it is the same on every endorsing peer.
+func GetTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("GetTxTime failed because stub.GetTxTimestamp failed with error %v", err)
+	}
+	return time.Unix(ts.GetSeconds(), int64(ts.GetNanos())).UTC(), nil
+}
+
+// maxTrackedTxs bounds the number of invocations whose in-memory state is remembered.
+// It is far above the number of transactions a peer simulates at the same time.
+const maxTrackedTxs = 1024
+
+// txState is what util remembers about a chaincode invocation between calls. Chaincode
+// cannot read its own writes, so this is how several calls made by one invocation agree
+// with each other.
+//
+// The state belongs to one invocation, not to its transaction ID: a transaction can be
+// simulated again with the same ID, by another peer, in a dry run or by the endorsement
+// check of the mock, and every simulation must start from scratch to produce the same
+// read/write set. The shim hands every invocation a signed proposal of its own, which
+// tells invocations apart. The state of an invocation that has ended is never looked up
+// again and is dropped once maxTrackedTxs newer invocations have state.
+type txState struct {
+	sequences map[string]uint64
+	values    map[string]interface{}
+}
+
+// txInvocation identifies a chaincode invocation
+type txInvocation struct {
+	txID     string
+	proposal *pb.SignedProposal
+}
+
+var (
+	txStatesLock sync.Mutex
+	txStates     = map[txInvocation]*txState{}
+	txStateOrder = make([]txInvocation, 0, maxTrackedTxs)
+)
+
+// getTxState returns the state of the invocation of stub. The caller must hold txStatesLock.
+func getTxState(stub shim.ChaincodeStubInterface) *txState {
+	invocation := txInvocation{txID: stub.GetTxID()}
+	if proposal, err := stub.GetSignedProposal(); err == nil {
+		invocation.proposal = proposal
+	}
+	state, found := txStates[invocation]
+	if !found {
+		if len(txStateOrder) == maxTrackedTxs {
+			delete(txStates, txStateOrder[0])
+			txStateOrder = txStateOrder[1:]
+		}
+		state = &txState{sequences: map[string]uint64{}, values: map[string]interface{}{}}
+		txStates[invocation] = state
+		txStateOrder = append(txStateOrder, invocation)
+	}
+	return state
+}
+
+// nextTxSequence returns 0, 1, 2, ... on successive calls made by the same invocation
+// for the same scope, so that several writes of one transaction get distinct yet
+// deterministic keys.
+func nextTxSequence(stub shim.ChaincodeStubInterface, scope string) uint64 {
+	txStatesLock.Lock()
+	defer txStatesLock.Unlock()
+	state := getTxState(stub)
+	next := state.sequences[scope]
+	state.sequences[scope] = next + 1
+	return next
+}
+
+// rememberTxValue keeps a value for the rest of the invocation of stub.
+func rememberTxValue(stub shim.ChaincodeStubInterface, scope string, value interface{}) {
+	txStatesLock.Lock()
+	defer txStatesLock.Unlock()
+	getTxState(stub).values[scope] = value
+}
+
+// recallTxValue returns a value kept by rememberTxValue during the same invocation.
+func recallTxValue(stub shim.ChaincodeStubInterface, scope string) (interface{}, bool) {
+	txStatesLock.Lock()
+	defer txStatesLock.Unlock()
+	value, found := getTxState(stub).values[scope]
+	return value, found
+}
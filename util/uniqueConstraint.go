@@ -0,0 +1,207 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package util
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// UniqueIndexPrefix is the object type of the reservation keys that enforce the unique
+// fields of a table. A reservation key is formed by (table name, field, value) and holds
+// the composite key of the row that owns the value.
+const UniqueIndexPrefix = "AKC~UNIQUE"
+
+// UniqueViolationError is returned when a write would give a unique field a value
+// that is already held by another row of the same table.
+type UniqueViolationError struct {
+	Table string
+	Field string
+	Value string
+}
+
+func (e *UniqueViolationError) Error() string {
+	return fmt.Sprintf("unique violation: value %q of field %s is already used by another row of table %s", e.Value, e.Field, e.Table)
+}
+
+// IsUniqueViolation reports whether err (or any error it wraps) is a UniqueViolationError.
+func IsUniqueViolation(err error) bool {
+	var violation *UniqueViolationError
+	return errors.As(err, &violation)
+}
+
+// GetTableRowByUniqueField looks a row up by the value of one of its unique fields.
+// If row_value is nil, only the presence of the row is checked.
+func GetTableRowByUniqueField(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	field string,
+	value string,
+	row_value interface{},
+) (rowWasFound bool, err error) {
+	reservationKey, err := stub.CreateCompositeKey(UniqueIndexPrefix, []string{table_name, field, value})
+	if err != nil {
+		return false, fmt.Errorf("GetTableRowByUniqueField failed because stub.CreateCompositeKey failed with error %v", err)
+	}
+	owner, err := stub.GetState(reservationKey)
+	if err != nil {
+		return false, fmt.Errorf("GetTableRowByUniqueField failed because stub.GetState(%v) failed with error %v", reservationKey, err)
+	}
+	if owner == nil {
+		return false, nil
+	}
+	_, row_keys, err := stub.SplitCompositeKey(string(owner))
+	if err != nil {
+		return false, fmt.Errorf("GetTableRowByUniqueField failed because stub.SplitCompositeKey failed with error %v", err)
+	}
+	return GetTableRow(stub, table_name, row_keys, row_value, DONT_FAIL_IF_MISSING)
+}
+
+// updateUniqueReservations reserves the unique field values of new_bytes for the row stored
+// under composite_key and releases the values of old_bytes that the row no longer uses.
+// new_bytes is nil when the row is being deleted. Every constraint is checked before any
+// reservation is written so that a violation leaves the state untouched.
+func updateUniqueReservations(
+	stub shim.ChaincodeStubInterface,
+	schema TableSchema,
+	table_name string,
+	composite_key string,
+	old_bytes []byte,
+	new_bytes []byte,
+) error {
+	if len(schema.UniqueFields) == 0 {
+		return nil
+	}
+
+	oldRow, err := decodeRow(old_bytes)
+	if err != nil {
+		return err
+	}
+	newRow, err := decodeRow(new_bytes)
+	if err != nil {
+		return err
+	}
+	// A soft deleted row does not hold on to its unique values
+	if _, deleted := oldRow[TombstoneField]; deleted {
+		oldRow = nil
+	}
+	if _, deleted := newRow[TombstoneField]; deleted {
+		newRow = nil
+	}
+
+	type change struct {
+		oldValue, newValue string
+		hasOld, hasNew     bool
+	}
+	changes := make(map[string]change, len(schema.UniqueFields))
+
+	for _, field := range schema.UniqueFields {
+		var c change
+		if c.oldValue, c.hasOld, err = fieldValueString(oldRow, field); err != nil {
+			return err
+		}
+		if c.newValue, c.hasNew, err = fieldValueString(newRow, field); err != nil {
+			return err
+		}
+		changes[field] = c
+		if !c.hasNew || (c.hasOld && c.oldValue == c.newValue) {
+			continue
+		}
+
+		reservationKey, err := stub.CreateCompositeKey(UniqueIndexPrefix, []string{table_name, field, c.newValue})
+		if err != nil {
+			return fmt.Errorf("updateUniqueReservations failed because stub.CreateCompositeKey failed with error %v", err)
+		}
+		owner, err := getReservationOwner(stub, reservationKey)
+		if err != nil {
+			return fmt.Errorf("updateUniqueReservations failed because getReservationOwner failed with error %v", err)
+		}
+		if owner != "" && owner != composite_key {
+			return &UniqueViolationError{Table: table_name, Field: field, Value: c.newValue}
+		}
+	}
+
+	for _, field := range schema.UniqueFields {
+		c := changes[field]
+		if c.hasOld && c.hasNew && c.oldValue == c.newValue {
+			continue
+		}
+		if c.hasOld {
+			if err := releaseUniqueValue(stub, table_name, field, c.oldValue, composite_key); err != nil {
+				return err
+			}
+		}
+		if c.hasNew {
+			reservationKey, _ := stub.CreateCompositeKey(UniqueIndexPrefix, []string{table_name, field, c.newValue})
+			if err := stub.PutState(reservationKey, []byte(composite_key)); err != nil {
+				return fmt.Errorf("updateUniqueReservations failed because stub.PutState(%v) failed with error %v", reservationKey, err)
+			}
+			getTxReservations(stub)[reservationKey] = composite_key
+		}
+	}
+	return nil
+}
+
+// releaseUniqueValue deletes a reservation key, but only if it is still owned by composite_key.
+func releaseUniqueValue(stub shim.ChaincodeStubInterface, table_name string, field string, value string, composite_key string) error {
+	reservationKey, err := stub.CreateCompositeKey(UniqueIndexPrefix, []string{table_name, field, value})
+	if err != nil {
+		return fmt.Errorf("releaseUniqueValue failed because stub.CreateCompositeKey failed with error %v", err)
+	}
+	owner, err := getReservationOwner(stub, reservationKey)
+	if err != nil {
+		return fmt.Errorf("releaseUniqueValue failed because getReservationOwner failed with error %v", err)
+	}
+	if owner != composite_key {
+		return nil
+	}
+	if err := stub.DelState(reservationKey); err != nil {
+		return fmt.Errorf("releaseUniqueValue failed because stub.DelState(%v) failed with error %v", reservationKey, err)
+	}
+	getTxReservations(stub)[reservationKey] = ""
+	return nil
+}
+
+// getReservationOwner returns the composite key of the row that owns a reservation key, or
+// "" if the value is free. A peer only reads the committed state, so the reservations made
+// and released earlier in the invocation are taken from memory.
+func getReservationOwner(stub shim.ChaincodeStubInterface, reservationKey string) (string, error) {
+	if owner, found := getTxReservations(stub)[reservationKey]; found {
+		return owner, nil
+	}
+	owner, err := stub.GetState(reservationKey)
+	if err != nil {
+		return "", fmt.Errorf("getReservationOwner failed because stub.GetState(%v) failed with error %v", reservationKey, err)
+	}
+	return string(owner), nil
+}
+
+// getTxReservations returns the reservation keys written by the invocation, with their
+// owners, "" for a released value.
+func getTxReservations(stub shim.ChaincodeStubInterface) map[string]string {
+	if remembered, found := recallTxValue(stub, UniqueIndexPrefix); found {
+		return remembered.(map[string]string)
+	}
+	reservations := make(map[string]string)
+	rememberTxValue(stub, UniqueIndexPrefix, reservations)
+	return reservations
+}
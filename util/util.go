@@ -53,14 +53,24 @@ const (
 	FAIL_IF_MISSING      GetTableRow_FailureOption = true
 )
 
-// Implementation of GetTableKey that returns the composite key, if the row was found, and error.
+// This is effectively a strongly typed enum declaration.
+// It only matters for tables whose schema enables SoftDelete.
+type DeletedRow_Option bool
+
+const (
+	EXCLUDE_DELETED DeletedRow_Option = false
+	INCLUDE_DELETED DeletedRow_Option = true
+)
+
+// Implementation of GetTableKey that returns the composite key, the raw row, if the row was found, and error.
 func getTableRowAndCompositeKey(
 	stub shim.ChaincodeStubInterface,
 	table_name string,
 	row_keys []string,
 	row_value interface{},
 	failure_option GetTableRow_FailureOption,
-) (composite_key string, rowWasFound bool, err error) {
+	deleted_option DeletedRow_Option,
+) (composite_key string, row_bytes []byte, rowWasFound bool, err error) {
 	// Initialize this to default not-found.
 	rowWasFound = false
 
@@ -94,9 +104,19 @@ func getTableRowAndCompositeKey(
 		}
 		return
 	}
+	if deleted_option == EXCLUDE_DELETED && isTombstoned(table_name, bytes) {
+		// A soft deleted row is reported exactly like a missing one.
+		if failure_option == FAIL_IF_MISSING {
+			err = fmt.Errorf("GetTableRow failed because row with keys %v has been deleted", row_keys)
+		} else {
+			err = nil
+		}
+		return
+	}
 
 	// If we got this far, then the row was found.
 	rowWasFound = true
+	row_bytes = bytes
 
 	// If row_value is not nil, attempt to unmarshal the row.
 	if !InterfaceIsNilOrIsZeroOfUnderlyingType(row_value) {
@@ -121,7 +141,20 @@ func GetTableRow(
 	row_value interface{},
 	failure_option GetTableRow_FailureOption,
 ) (rowWasFound bool, err error) {
-	_, rowWasFound, err = getTableRowAndCompositeKey(stub, table_name, row_keys, row_value, failure_option)
+	_, _, rowWasFound, err = getTableRowAndCompositeKey(stub, table_name, row_keys, row_value, failure_option, EXCLUDE_DELETED)
+	return
+}
+
+// GetTableRowIncludeDeleted works like GetTableRow but also returns rows that have been
+// soft deleted. Use GetTableRowTombstone to find out whether the row is deleted.
+func GetTableRowIncludeDeleted(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	row_keys []string,
+	row_value interface{},
+	failure_option GetTableRow_FailureOption,
+) (rowWasFound bool, err error) {
+	_, _, rowWasFound, err = getTableRowAndCompositeKey(stub, table_name, row_keys, row_value, failure_option, INCLUDE_DELETED)
 	return
 }
 
@@ -131,6 +164,24 @@ func GetTableRows(
 	stub shim.ChaincodeStubInterface,
 	table_name string,
 	row_keys []string,
+) (chan []byte, error) {
+	return getTableRows(stub, table_name, row_keys, EXCLUDE_DELETED)
+}
+
+// GetTableRowsIncludeDeleted works like GetTableRows but also returns rows that have been soft deleted.
+func GetTableRowsIncludeDeleted(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	row_keys []string,
+) (chan []byte, error) {
+	return getTableRows(stub, table_name, row_keys, INCLUDE_DELETED)
+}
+
+func getTableRows(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	row_keys []string,
+	deleted_option DeletedRow_Option,
 ) (chan []byte, error) {
 	state_query_iterator, err := stub.GetStateByPartialCompositeKey(table_name, row_keys)
 	if err != nil {
@@ -145,6 +196,9 @@ func GetTableRows(
 			if err != nil {
 				panic("this should never happen probably")
 			}
+			if deleted_option == EXCLUDE_DELETED && isTombstoned(table_name, query_result_kv.Value) {
+				continue
+			}
 			rowJSONBytesChannel <- query_result_kv.Value
 		}
 		close(rowJSONBytesChannel)
@@ -185,13 +239,26 @@ func InsertTableRow(
 		return
 	}
 
+	// Check the validation tags of new_row_value, reporting all the violations at once
+	err = ValidateStruct(new_row_value)
+	if err != nil {
+		err = fmt.Errorf("InsertTableRow failed because %w", err)
+		return
+	}
+
 	// Check for the row's presence and retrieve its value into old_row_value if specified
-	composite_key, rowWasFound, err := getTableRowAndCompositeKey(stub, table_name, row_keys, old_row_value, DONT_FAIL_IF_MISSING)
+	composite_key, old_bytes, rowWasFound, err := getTableRowAndCompositeKey(stub, table_name, row_keys, old_row_value, DONT_FAIL_IF_MISSING, INCLUDE_DELETED)
 	if err != nil {
 		err = fmt.Errorf("InsertTableRow failed because getTableRowAndCompositeKey failed with error %v", err)
 		return
 	}
 
+	// A soft deleted row keeps its keys until it is restored or purged
+	if rowWasFound && isTombstoned(table_name, old_bytes) {
+		err = fmt.Errorf("InsertTableRow failed because the row with keys %v has been deleted; restore or purge it first", row_keys)
+		return
+	}
+
 	// Process the failure_option
 	if failure_option == FAIL_BEFORE_OVERWRITE && rowWasFound {
 		err = fmt.Errorf("InsertTableRow failed because the row existed already and FAIL_BEFORE_OVERWRITE was specified")
@@ -208,6 +275,13 @@ func InsertTableRow(
 		return
 	}
 
+	// Apply the constraints declared in the table schema
+	bytes, err = applyTableSchema(stub, table_name, composite_key, old_bytes, bytes)
+	if err != nil {
+		err = fmt.Errorf("InsertTableRow failed because applyTableSchema failed with error %w", err)
+		return
+	}
+
 	// Store the data in the ledger state
 	err = stub.PutState(composite_key, bytes)
 	if err != nil {
@@ -226,6 +300,18 @@ func UpdateTableRow(
 	table_name string,
 	row_keys []string,
 	new_row_value interface{},
+) (err error) {
+	return updateTableRow(stub, table_name, row_keys, new_row_value, nil)
+}
+
+// updateTableRow implements UpdateTableRow. old_bytes is the stored row if the caller has
+// already read it, otherwise nil and the row is read when the table schema needs it.
+func updateTableRow(
+	stub shim.ChaincodeStubInterface,
+	table_name string,
+	row_keys []string,
+	new_row_value interface{},
+	old_bytes []byte,
 ) (err error) {
 	err = nil
 
@@ -235,6 +321,13 @@ func UpdateTableRow(
 		return
 	}
 
+	// Check the validation tags of new_row_value, reporting all the violations at once
+	err = ValidateStruct(new_row_value)
+	if err != nil {
+		err = fmt.Errorf("UpdateTableRow failed because %w", err)
+		return
+	}
+
 	// Form the composite key that will index this table row in the ledger state key/value store.
 	compositeKey, err := stub.CreateCompositeKey(table_name, row_keys)
 	if err != nil {
@@ -249,6 +342,24 @@ func UpdateTableRow(
 		return
 	}
 
+	// Some table schemas can only be applied by looking at the row being replaced
+	if schema, found := GetTableSchema(table_name); found && schema.needsPreviousRow() && old_bytes == nil {
+		old_bytes, err = stub.GetState(compositeKey)
+		if err != nil {
+			err = fmt.Errorf("UpdateTableRow failed because stub.GetState(%v) failed with error %v", compositeKey, err)
+			return
+		}
+		if isTombstoned(table_name, old_bytes) {
+			err = fmt.Errorf("UpdateTableRow failed because the row with keys %v has been deleted", row_keys)
+			return
+		}
+	}
+	bytes, err = applyTableSchema(stub, table_name, compositeKey, old_bytes, bytes)
+	if err != nil {
+		err = fmt.Errorf("UpdateTableRow failed because applyTableSchema failed with error %w", err)
+		return
+	}
+
 	// Store the data in the ledger state
 	err = stub.PutState(compositeKey, bytes)
 	if err != nil {
@@ -262,6 +373,7 @@ func UpdateTableRow(
 }
 
 // If old_row_value is not nil, then the table row will be unmarshaled into old_row_value before being deleted.
+// On tables whose schema enables SoftDelete the row is kept with a tombstone instead; see PurgeTableRow.
 func DeleteTableRow(
 	stub shim.ChaincodeStubInterface,
 	table_name string,
@@ -273,7 +385,7 @@ func DeleteTableRow(
 	err = nil
 
 	// Check for the row's presence and retrieve its value into old_row_value if specified
-	composite_key, rowWasFound, err := getTableRowAndCompositeKey(stub, table_name, row_keys, old_row_value, DONT_FAIL_IF_MISSING)
+	composite_key, old_bytes, rowWasFound, err := getTableRowAndCompositeKey(stub, table_name, row_keys, old_row_value, DONT_FAIL_IF_MISSING, EXCLUDE_DELETED)
 	if err != nil {
 		err = fmt.Errorf("DeleteTableRow failed because getTableRowAndCompositeKey failed with error %v", err)
 		return
@@ -285,14 +397,45 @@ func DeleteTableRow(
 		return
 	}
 
+	// A missing row has nothing for the table schema to clean up
+	if !rowWasFound {
+		if schema, found := GetTableSchema(table_name); found && schema.SoftDelete {
+			return
+		}
+		err = stub.DelState(composite_key)
+		if err != nil {
+			err = fmt.Errorf("DeleteTableRow failed because stub.DelState(%v) failed with error %v", composite_key, err)
+		}
+		return
+	}
+	err = deleteTableRow(stub, table_name, composite_key, old_bytes)
+	if err != nil {
+		err = fmt.Errorf("DeleteTableRow failed because %w", err)
+	}
+	return
+}
+
+// deleteTableRow deletes the stored row old_bytes, which the caller has already read.
+func deleteTableRow(stub shim.ChaincodeStubInterface, table_name string, composite_key string, old_bytes []byte) error {
+	// Tables with SoftDelete keep the row and only mark it as deleted
+	if schema, found := GetTableSchema(table_name); found && schema.SoftDelete {
+		err := softDeleteRow(stub, table_name, composite_key, old_bytes)
+		if err != nil {
+			return fmt.Errorf("softDeleteRow failed with error %w", err)
+		}
+		return nil
+	}
+
+	// Let the table schema clean up after the row
+	_, err := applyTableSchema(stub, table_name, composite_key, old_bytes, nil)
+	if err != nil {
+		return fmt.Errorf("applyTableSchema failed with error %w", err)
+	}
+
 	// Actually delete the row
 	err = stub.DelState(composite_key)
 	if err != nil {
-		err = fmt.Errorf("DeleteTableRow failed because stub.DelState(%v) failed with error %v", composite_key, err)
-		return
+		return fmt.Errorf("stub.DelState(%v) failed with error %v", composite_key, err)
 	}
-
-	// Return with success
-	err = nil
-	return
+	return nil
 }
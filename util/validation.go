@@ -0,0 +1,424 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ValidateTag is the struct tag read by ValidateStruct. Its value is a comma separated list of
+// rules, e.g. `akc:"required,minlen=3,maxlen=64"`:
+//
+//	required      the value must not be zero, nil or empty
+//	min=N, max=N  bounds of a number
+//	len=N         exact length of a string (in characters), slice or map
+//	minlen=N      minimum length
+//	maxlen=N      maximum length
+//	enum=A|B|C    the value must be one of the listed ones
+//	regex=EXPR    a string must match the regular expression; it must be the last rule since
+//	              the expression may contain commas
+//	dive          the rules that follow apply to every item of a slice or map
+//
+// Nil pointers are only checked by required. Nested structs, and structs held by slices,
+// maps and pointers, are always validated. Violations are listed in the order of the fields,
+// and of the keys of maps, so the error is the same on every endorsing peer.
+//
+// The tag is not named validate, so that models already tagged for other validation
+// libraries, e.g. go-playground/validator, are not affected.
+const ValidateTag = "akc"
+
+// FieldViolation is a rule that a field does not satisfy. Field is the path of the field
+// made of its JSON names, e.g. "Items[2].Name".
+type FieldViolation struct {
+	Field   string `json:"Field"`
+	Rule    string `json:"Rule"`
+	Message string `json:"Message"`
+}
+
+// ValidationError lists every violation found in a value.
+type ValidationError struct {
+	Violations []FieldViolation
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = violation.Field + " " + violation.Message
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+// IsValidationError reports whether err, or an error it wraps, is a ValidationError.
+func IsValidationError(err error) bool {
+	var validation *ValidationError
+	return errors.As(err, &validation)
+}
+
+// ValidateStruct checks value against the ValidateTag of its fields and returns a
+// ValidationError holding all the violations. Values that are not structs or pointers to
+// structs have nothing to validate. An invalid tag is reported as a plain error.
+func ValidateStruct(value interface{}) error {
+	violations := make([]FieldViolation, 0)
+	if err := validateValue(reflect.ValueOf(value), "", &violations); err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
+
+// DecodeArgument unmarshals a JSON chaincode argument into value and validates the result
+// with ValidateStruct.
+func DecodeArgument(arg string, value interface{}) error {
+	if err := json.Unmarshal([]byte(arg), value); err != nil {
+		return fmt.Errorf("DecodeArgument failed because json.Unmarshal failed with error %v", err)
+	}
+	return ValidateStruct(value)
+}
+
+type validationRule struct {
+	name   string
+	param  string
+	number float64
+	enum   []string
+	regex  *regexp.Regexp
+}
+
+type fieldValidation struct {
+	index     []int
+	name      string // JSON name, empty for embedded structs whose fields are promoted
+	rules     []validationRule
+	itemRules []validationRule
+}
+
+var validationCache sync.Map // reflect.Type -> []fieldValidation
+
+func validateValue(v reflect.Value, path string, violations *[]FieldViolation) error {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), violations); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		for _, key := range sortedMapKeys(v) {
+			if err := validateValue(v.MapIndex(key), fmt.Sprintf("%s[%v]", path, key), violations); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+	default:
+		return nil
+	}
+
+	fields, err := structValidations(v.Type())
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		fv := v.FieldByIndex(field.index)
+		fieldPath := path
+		if field.name != "" {
+			fieldPath = joinFieldPath(path, field.name)
+		}
+		if err = applyValidationRules(field.rules, fv, fieldPath, violations); err != nil {
+			return err
+		}
+		if len(field.itemRules) > 0 {
+			if err = applyItemRules(field.itemRules, fv, fieldPath, violations); err != nil {
+				return err
+			}
+		}
+		if err = validateValue(fv, fieldPath, violations); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyItemRules(rules []validationRule, v reflect.Value, path string, violations *[]FieldViolation) error {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := applyValidationRules(rules, v.Index(i), fmt.Sprintf("%s[%d]", path, i), violations); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, key := range sortedMapKeys(v) {
+			if err := applyValidationRules(rules, v.MapIndex(key), fmt.Sprintf("%s[%v]", path, key), violations); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("ValidateStruct failed because dive is used on %s, which is a %s", path, v.Kind())
+	}
+	return nil
+}
+
+// sortedMapKeys returns the keys of a map sorted by their printed form, the order of a
+// map iteration changing every time
+func sortedMapKeys(v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+	return keys
+}
+
+func applyValidationRules(rules []validationRule, v reflect.Value, path string, violations *[]FieldViolation) error {
+	for _, rule := range rules {
+		if rule.name == "required" {
+			if isEmptyValue(v) {
+				*violations = append(*violations, FieldViolation{Field: path, Rule: "required", Message: "is required"})
+				// The other rules would only report the same problem again
+				return nil
+			}
+			continue
+		}
+
+		value := v
+		for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+			if value.IsNil() {
+				return nil
+			}
+			value = value.Elem()
+		}
+		message, err := checkValidationRule(rule, value)
+		if err != nil {
+			return fmt.Errorf("ValidateStruct failed because rule %s cannot be applied to %s: %v", rule.name, path, err)
+		}
+		if message != "" {
+			*violations = append(*violations, FieldViolation{Field: path, Rule: rule.name, Message: message})
+		}
+	}
+	return nil
+}
+
+// checkValidationRule returns a message describing the violation, or "" if the rule is satisfied.
+func checkValidationRule(rule validationRule, v reflect.Value) (string, error) {
+	switch rule.name {
+	case "min", "max":
+		var number float64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			number = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			number = float64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			number = v.Float()
+		default:
+			return "", fmt.Errorf("a %s is not a number", v.Kind())
+		}
+		if rule.name == "min" && number < rule.number {
+			return "must be at least " + rule.param, nil
+		}
+		if rule.name == "max" && number > rule.number {
+			return "must be at most " + rule.param, nil
+		}
+	case "len", "minlen", "maxlen":
+		var length int
+		switch v.Kind() {
+		case reflect.String:
+			length = utf8.RuneCountInString(v.String())
+		case reflect.Slice, reflect.Array, reflect.Map:
+			length = v.Len()
+		default:
+			return "", fmt.Errorf("a %s has no length", v.Kind())
+		}
+		limit := int(rule.number)
+		switch {
+		case rule.name == "len" && length != limit:
+			return fmt.Sprintf("must have a length of %d", limit), nil
+		case rule.name == "minlen" && length < limit:
+			return fmt.Sprintf("must have a length of at least %d", limit), nil
+		case rule.name == "maxlen" && length > limit:
+			return fmt.Sprintf("must have a length of at most %d", limit), nil
+		}
+	case "enum":
+		var value string
+		switch v.Kind() {
+		case reflect.String:
+			value = v.String()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			value = fmt.Sprint(v.Interface())
+		default:
+			return "", fmt.Errorf("a %s cannot be compared to a list of values", v.Kind())
+		}
+		for _, allowed := range rule.enum {
+			if value == allowed {
+				return "", nil
+			}
+		}
+		return fmt.Sprintf("must be one of %s", strings.Join(rule.enum, ", ")), nil
+	case "regex":
+		if v.Kind() != reflect.String {
+			return "", fmt.Errorf("a %s is not a string", v.Kind())
+		}
+		if !rule.regex.MatchString(v.String()) {
+			return "must match " + rule.param, nil
+		}
+	}
+	return "", nil
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	default:
+		return v.IsZero()
+	}
+}
+
+// structValidations returns the parsed tags of a struct type, parsing them the first time.
+func structValidations(t reflect.Type) ([]fieldValidation, error) {
+	if cached, found := validationCache.Load(t); found {
+		return cached.([]fieldValidation), nil
+	}
+
+	fields := make([]fieldValidation, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !(field.Anonymous && field.Type.Kind() == reflect.Struct) {
+			continue // unexported
+		}
+		name := field.Name
+		if jsonTag, found := field.Tag.Lookup("json"); found {
+			jsonName := strings.Split(jsonTag, ",")[0]
+			if jsonName == "-" {
+				continue
+			}
+			if jsonName != "" {
+				name = jsonName
+			} else if field.Anonymous {
+				name = ""
+			}
+		} else if field.Anonymous {
+			name = ""
+		}
+
+		rules, itemRules, err := parseValidateTag(field.Tag.Get(ValidateTag))
+		if err != nil {
+			return nil, fmt.Errorf("ValidateStruct failed because the %s tag of %s.%s is invalid: %v", ValidateTag, t.Name(), field.Name, err)
+		}
+		fields = append(fields, fieldValidation{index: field.Index, name: name, rules: rules, itemRules: itemRules})
+	}
+
+	validationCache.Store(t, fields)
+	return fields, nil
+}
+
+func parseValidateTag(tag string) (rules []validationRule, itemRules []validationRule, err error) {
+	if tag == "" {
+		return nil, nil, nil
+	}
+	current := &rules
+	rest := tag
+	for rest != "" {
+		var item string
+		if strings.HasPrefix(rest, "regex=") {
+			item, rest = rest, ""
+		} else if comma := strings.IndexByte(rest, ','); comma >= 0 {
+			item, rest = rest[:comma], rest[comma+1:]
+		} else {
+			item, rest = rest, ""
+		}
+
+		name, param := item, ""
+		if equals := strings.IndexByte(item, '='); equals >= 0 {
+			name, param = item[:equals], item[equals+1:]
+		}
+		rule := validationRule{name: name, param: param}
+		switch name {
+		case "dive":
+			if current == &itemRules {
+				return nil, nil, fmt.Errorf("dive can only be used once")
+			}
+			current = &itemRules
+			continue
+		case "required":
+		case "min", "max":
+			if rule.number, err = strconv.ParseFloat(param, 64); err != nil {
+				return nil, nil, fmt.Errorf("%s needs a number", name)
+			}
+		case "len", "minlen", "maxlen":
+			length, err := strconv.Atoi(param)
+			if err != nil || length < 0 {
+				return nil, nil, fmt.Errorf("%s needs a length", name)
+			}
+			rule.number = float64(length)
+		case "enum":
+			if param == "" {
+				return nil, nil, fmt.Errorf("enum needs values")
+			}
+			rule.enum = strings.Split(param, "|")
+		case "regex":
+			if rule.regex, err = regexp.Compile(param); err != nil {
+				return nil, nil, fmt.Errorf("regex does not compile: %v", err)
+			}
+		default:
+			return nil, nil, fmt.Errorf("unknown rule %q", name)
+		}
+		*current = append(*current, rule)
+	}
+	return rules, itemRules, nil
+}
+
+func joinFieldPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2021 akachain
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// VersionConflictError is returned when a write on a versioned table carries a version
+// that differs from the one currently stored, i.e. the caller has read a stale row.
+type VersionConflictError struct {
+	Table    string
+	RowKeys  []string
+	Expected uint64 // the version carried by the caller
+	Actual   uint64 // the version currently stored
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("version conflict: row %v of table %s is at version %d but the write expected version %d", e.RowKeys, e.Table, e.Actual, e.Expected)
+}
+
+// IsVersionConflict reports whether err (or any error it wraps) is a VersionConflictError.
+func IsVersionConflict(err error) bool {
+	var conflict *VersionConflictError
+	return errors.As(err, &conflict)
+}
+
+// GetTableRowVersion returns the version of a row of a versioned table.
+// A missing row has version 0.
+func GetTableRowVersion(stub shim.ChaincodeStubInterface, table_name string, row_keys []string) (uint64, error) {
+	schema, found := GetTableSchema(table_name)
+	if !found || schema.VersionField == "" {
+		return 0, fmt.Errorf("GetTableRowVersion failed because table %s has no VersionField", table_name)
+	}
+	composite_key, row_bytes, _, err := getTableRowAndCompositeKey(stub, table_name, row_keys, nil, DONT_FAIL_IF_MISSING, INCLUDE_DELETED)
+	if err != nil {
+		return 0, fmt.Errorf("GetTableRowVersion failed because getTableRowAndCompositeKey failed with error %v", err)
+	}
+	row, err := decodeRow(row_bytes)
+	if err != nil {
+		return 0, err
+	}
+	return rowVersion(row, schema.VersionField, table_name, composite_key)
+}
+
+// stampRowVersion checks the version carried by new_bytes against the one stored in
+// old_bytes and returns new_bytes with the version field set to the next version.
+func stampRowVersion(
+	stub shim.ChaincodeStubInterface,
+	schema TableSchema,
+	table_name string,
+	composite_key string,
+	old_bytes []byte,
+	new_bytes []byte,
+) ([]byte, error) {
+	if schema.VersionField == "" || new_bytes == nil {
+		return new_bytes, nil
+	}
+
+	oldRow, err := decodeRow(old_bytes)
+	if err != nil {
+		return nil, err
+	}
+	newRow, err := decodeRow(new_bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var nextVersion uint64 = 1
+	if oldRow != nil {
+		actual, err := rowVersion(oldRow, schema.VersionField, table_name, composite_key)
+		if err != nil {
+			return nil, err
+		}
+		expected, err := rowVersion(newRow, schema.VersionField, table_name, composite_key)
+		if err != nil {
+			return nil, err
+		}
+		if expected != actual {
+			_, row_keys, _ := stub.SplitCompositeKey(composite_key)
+			return nil, &VersionConflictError{Table: table_name, RowKeys: row_keys, Expected: expected, Actual: actual}
+		}
+		nextVersion = actual + 1
+	}
+
+	newRow[schema.VersionField] = json.Number(strconv.FormatUint(nextVersion, 10))
+	bytes, err := json.Marshal(newRow)
+	if err != nil {
+		return nil, fmt.Errorf("stampRowVersion failed because json.Marshal failed with error %v", err)
+	}
+	return bytes, nil
+}
+
+// rowVersion reads the version field of a decoded row. A row without the field is at version 0.
+func rowVersion(row map[string]interface{}, field string, table_name string, composite_key string) (uint64, error) {
+	value, found, err := fieldValueString(row, field)
+	if err != nil || !found {
+		return 0, err
+	}
+	version, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("rowVersion failed because field %s of row %q in table %s is not a version number: %v", field, composite_key, table_name, err)
+	}
+	return version, nil
+}